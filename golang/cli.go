@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"generate-code/service"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cliFlags are the options accepted in -cli mode, named after the web
+// form fields.
+type cliFlags struct {
+	input        string
+	output       string
+	format       string
+	zipMode      string
+	zipMethod    string
+	pngLevel     string
+	layout       string
+	placeholder  string
+	columnMap    string
+	content      string
+	fallback     string
+	card         string
+	cardColumns  string
+	phoneFormat  string
+	sheet        string
+	sheets       string
+	dirMode      string
+	fileMode     string
+	lang         string
+	maxOutputMB  int
+	maxZipMB     int
+	validateOnly bool
+	failFast     bool
+	dedup        bool
+	strictNIK    bool
+	wifi         bool
+	manifest     bool
+	fileTree     bool
+}
+
+func (f *cliFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.input, "input", "", "spreadsheet to read (.xlsx, .xls, .ods, .csv, .tsv, .txt)")
+	fs.StringVar(&f.output, "output", "", "folder to write the QR codes into")
+	fs.StringVar(&f.format, "format", "", "image format: png, svg, jpeg or webp")
+	fs.StringVar(&f.zipMode, "zip-mode", "", "single, per-kecamatan or none")
+	fs.StringVar(&f.zipMethod, "zip-compression", "", "deflate or store")
+	fs.StringVar(&f.pngLevel, "png-compression", "", "best, default, speed or none")
+	fs.StringVar(&f.layout, "folder-layout", "", "columns, version or version-columns")
+	fs.StringVar(&f.placeholder, "folder-placeholder", "", "folder name for empty KECAMATAN/KELURAHAN cells, e.g. Unknown")
+	fs.StringVar(&f.columnMap, "column-map", "", `JSON column mapping, e.g. {"nik":"NIK"}`)
+	fs.StringVar(&f.content, "content-columns", "", "comma-separated columns to make one QR each from")
+	fs.StringVar(&f.fallback, "content-fallback", "", "template encoded when KODE QR is empty, e.g. {nik}")
+	fs.StringVar(&f.card, "card", "", "encode a contact card instead of KODE QR: mecard or vcard")
+	fs.StringVar(&f.cardColumns, "card-columns", "", `JSON card field to column mapping, e.g. {"phone":"NO HP"}`)
+	fs.StringVar(&f.phoneFormat, "card-phone-format", "", "card phone number format: plus, digits or grouped")
+	fs.StringVar(&f.sheet, "sheet", "", "sheet name or 1-based number")
+	fs.StringVar(&f.sheets, "sheets", "", `comma-separated sheets to read as one dataset, or "*" for all`)
+	fs.StringVar(&f.dirMode, "dir-mode", "", "octal permissions of created folders, e.g. 775")
+	fs.StringVar(&f.fileMode, "file-mode", "", "octal permissions of created files, e.g. 664")
+	fs.StringVar(&f.lang, "lang", "", "language of row messages and warnings: en or id")
+	fs.IntVar(&f.maxOutputMB, "max-output-mb", 0, "stop and remove the images once they exceed this many MB")
+	fs.IntVar(&f.maxZipMB, "max-zip-mb", 0, "split each zip into parts of at most this many MB")
+	fs.BoolVar(&f.validateOnly, "validate-only", false, "check the rows without writing images")
+	fs.BoolVar(&f.failFast, "fail-fast", false, "stop at the first invalid or failed row")
+	fs.BoolVar(&f.dedup, "dedup", false, "skip rows repeating earlier QR content")
+	fs.BoolVar(&f.wifi, "wifi", false, "encode WiFi join payloads from the SSID, PASSWORD and AUTH columns")
+	fs.BoolVar(&f.strictNIK, "strict-nik", false, "reject NIKs with an impossible province code or birth date")
+	fs.BoolVar(&f.manifest, "manifest", false, "write manifest.csv into the output")
+	fs.BoolVar(&f.fileTree, "file-tree", false, "list the output folder's files in the result")
+}
+
+// runCLI generates from the command line and prints the Result as JSON.
+// It returns the process exit code: 1 when generation failed or any row
+// ended in an error, 2 for bad usage.
+func runCLI(f cliFlags) int {
+	if f.input == "" || (f.output == "" && !f.validateOnly) {
+		fmt.Fprintln(os.Stderr, "usage: -cli -input file.xlsx -output ./out [options]")
+		return 2
+	}
+
+	var columns map[string]string
+	if raw := strings.TrimSpace(f.columnMap); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &columns); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -column-map: %v\n", err)
+			return 2
+		}
+	}
+
+	var cardColumns map[string]string
+	if raw := strings.TrimSpace(f.cardColumns); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cardColumns); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -card-columns: %v\n", err)
+			return 2
+		}
+	}
+
+	var content []string
+	if raw := strings.TrimSpace(f.content); raw != "" {
+		for _, column := range strings.Split(raw, ",") {
+			content = append(content, strings.TrimSpace(column))
+		}
+	}
+
+	var sheets []string
+	if raw := strings.TrimSpace(f.sheets); raw != "" {
+		for _, sheet := range strings.Split(raw, ",") {
+			sheets = append(sheets, strings.TrimSpace(sheet))
+		}
+	}
+
+	var modes [2]os.FileMode
+	for i, raw := range []string{f.dirMode, f.fileMode} {
+		if raw == "" {
+			continue
+		}
+		mode, err := strconv.ParseUint(raw, 8, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid mode %q: want octal permissions such as 775\n", raw)
+			return 2
+		}
+		modes[i] = os.FileMode(mode)
+	}
+
+	result, err := service.Generate(context.Background(), service.Options{
+		FilePath:          f.input,
+		OutputFolder:      f.output,
+		Format:            f.format,
+		ZipMode:           f.zipMode,
+		ZipCompression:    f.zipMethod,
+		PNGCompression:    f.pngLevel,
+		FolderLayout:      f.layout,
+		FolderPlaceholder: f.placeholder,
+		Columns:           columns,
+		ContentColumns:    content,
+		ContentFallback:   f.fallback,
+		Card:              f.card,
+		CardColumns:       cardColumns,
+		CardPhoneFormat:   f.phoneFormat,
+		WiFi:              f.wifi,
+		Sheet:             f.sheet,
+		Sheets:            sheets,
+		ValidateOnly:      f.validateOnly,
+		FailFast:          f.failFast,
+		DedupContent:      f.dedup,
+		StrictNIK:         f.strictNIK,
+		Manifest:          f.manifest,
+		FileTree:          f.fileTree,
+		DirMode:           modes[0],
+		FileMode:          modes[1],
+		Lang:              f.lang,
+		MaxOutputMB:       f.maxOutputMB,
+		MaxZipBytes:       int64(f.maxZipMB) << 20,
+	})
+	if err != nil {
+		slog.Error("generation failed", "error", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		slog.Error("failed to print result", "error", err)
+		return 1
+	}
+	if len(result.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"generate-code/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIGenerate accepts the same multipart upload as Upload but responds
+// with the generation Result as JSON instead of rendering the web UI.
+// With stream=1 the response is the zip itself, generated in memory.
+func APIGenerate(c *fiber.Ctx) error {
+	if boolFormValue(c, "stream") {
+		if status, err := streamUpload(c); err != nil {
+			return jsonError(c, status, err)
+		}
+		return nil
+	}
+
+	result, _, status, err := processUpload(c)
+	var limitErr *service.OutputLimitError
+	if errors.As(err, &limitErr) {
+		// what was written before the run was stopped
+		return c.Status(status).JSON(fiber.Map{
+			"error":  localize(c, err),
+			"code":   errorCode(status),
+			"result": limitErr.Result,
+		})
+	}
+	if err != nil {
+		return jsonError(c, status, err)
+	}
+
+	return c.JSON(result)
+}
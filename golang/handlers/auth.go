@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireAPIKey rejects requests that don't carry key with 401. The key
+// may be sent as an X-API-Key header, a Bearer token, or the password of
+// HTTP Basic auth (any user name), so browsers can log in through their
+// own prompt. /healthz stays open for probes.
+func RequireAPIKey(key string) fiber.Handler {
+	want := []byte(key)
+	return func(c *fiber.Ctx) error {
+		if c.Path() == "/healthz" {
+			return c.Next()
+		}
+		if subtle.ConstantTimeCompare([]byte(requestAPIKey(c)), want) == 1 {
+			return c.Next()
+		}
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="generate-qr"`)
+		return jsonError(c, fiber.StatusUnauthorized, errMsg("unauthorized"))
+	}
+}
+
+// requestAPIKey extracts the key from whichever supported header is set.
+func requestAPIKey(c *fiber.Ctx) string {
+	if key := c.Get("X-API-Key"); key != "" {
+		return key
+	}
+	auth := c.Get(fiber.HeaderAuthorization)
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if encoded, ok := strings.CutPrefix(auth, "Basic "); ok {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return ""
+		}
+		_, password, _ := strings.Cut(string(decoded), ":")
+		return password
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"generate-code/service"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// cleanupEnabled reports whether CLEANUP_AFTER_ZIP asks for uploads and
+// uncompressed output to be removed after a successful run.
+func cleanupEnabled() bool {
+	switch os.Getenv("CLEANUP_AFTER_ZIP") {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// cleanupUpload removes the files saved for a successful upload.
+func cleanupUpload(up *upload) {
+	if !up.Options.Cleanup {
+		return
+	}
+	for _, path := range []string{up.FilePath, up.Options.LogoPath} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			slog.Warn("cleanup failed", "request_id", up.Options.RequestID, "path", path, "error", err)
+			continue
+		}
+		slog.Info("cleanup removed upload", "request_id", up.Options.RequestID, "path", path)
+	}
+}
+
+// StartZipSweeper deletes zips in OUTPUT_BASE older than ttl, checking
+// periodically for as long as the process runs.
+func StartZipSweeper(ttl time.Duration) {
+	outputBase := os.Getenv("OUTPUT_BASE")
+	if outputBase == "" {
+		outputBase = "./qr_output"
+	}
+
+	interval := min(ttl/2, time.Hour)
+	go func() {
+		for {
+			removed, err := service.SweepStaleZips(outputBase, ttl)
+			if err != nil && !os.IsNotExist(err) {
+				slog.Warn("zip sweep failed", "error", err)
+			}
+			for _, path := range removed {
+				slog.Info("sweeper removed stale zip", "path", path)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
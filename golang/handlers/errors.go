@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errorCodes are the machine-readable codes sent next to the message in
+// JSON error responses, so API clients don't have to match the
+// (Indonesian) text.
+var errorCodes = map[int]string{
+	fiber.StatusBadRequest:            "bad_request",
+	fiber.StatusUnauthorized:          "unauthorized",
+	fiber.StatusForbidden:             "forbidden",
+	fiber.StatusNotFound:              "not_found",
+	fiber.StatusMethodNotAllowed:      "method_not_allowed",
+	fiber.StatusRequestEntityTooLarge: "file_too_large",
+	fiber.StatusUpgradeRequired:       "upgrade_required",
+	fiber.StatusTooManyRequests:       "busy",
+	fiber.StatusInternalServerError:   "internal_error",
+	fiber.StatusBadGateway:            "bad_gateway",
+	fiber.StatusServiceUnavailable:    "unavailable",
+	fiber.StatusGatewayTimeout:        "timeout",
+	fiber.StatusInsufficientStorage:   "output_too_large",
+}
+
+// errorCode returns the code for an error response with status.
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	if status >= fiber.StatusInternalServerError {
+		return "internal_error"
+	}
+	return "bad_request"
+}
+
+// jsonError answers with a structured error:
+//
+//	{"error": "Ukuran file melebihi batas 10MB.", "code": "file_too_large"}
+//
+// error stays the human-readable message for existing clients, in the
+// request's language (see requestLang).
+func jsonError(c *fiber.Ctx, status int, err error) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error": localize(c, err),
+		"code":  errorCode(status),
+	})
+}
+
+// wantsJSON reports whether the error for this request should be JSON
+// rather than a page: API routes, and clients preferring JSON over HTML.
+func wantsJSON(c *fiber.Ctx) bool {
+	if strings.HasPrefix(c.Path(), "/api/") {
+		return true
+	}
+	return c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON
+}
+
+// ErrorHandler is the app's fiber.Config.ErrorHandler. It answers errors
+// no handler responded to, such as unknown routes or a body over
+// BodyLimit that fasthttp rejects before any handler runs, with JSON for
+// API clients and the error page for browsers.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	answer := errMsg("internal_error")
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		status, answer = fe.Code, fe
+	}
+	switch status {
+	case fiber.StatusRequestEntityTooLarge:
+		answer = errTooLarge()
+	case fiber.StatusNotFound:
+		answer = errMsg("page_not_found")
+	}
+	if status >= fiber.StatusInternalServerError {
+		slog.Error("request failed", "path", c.Path(), "status", status, "error", err)
+	}
+
+	if wantsJSON(c) {
+		return jsonError(c, status, answer)
+	}
+	c.Status(status)
+	if renderErr := c.Render("error", fiber.Map{
+		"Status": status,
+		"Code":   errorCode(status),
+		"Error":  localize(c, answer),
+	}); renderErr != nil {
+		return c.Status(status).SendString(localize(c, answer))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
+)
+
+// Files serves the output folders under OUTPUT_BASE with directory
+// listings, so runs generated without a zip can be fetched file by file.
+// It is mounted with app.Use; the base itself isn't listed, as it holds
+// every user's runs.
+func Files() fiber.Handler {
+	outputBase := os.Getenv("OUTPUT_BASE")
+	if outputBase == "" {
+		outputBase = "./qr_output"
+	}
+	browse := filesystem.New(filesystem.Config{
+		Root:   http.Dir(outputBase),
+		Browse: true,
+	})
+	return func(c *fiber.Ctx) error {
+		if strings.Trim(strings.TrimPrefix(c.Path(), "/files"), "/") == "" {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		return browse(c)
+	}
+}
+
+// folderURL is the Files link of outputFolder, or empty when nothing was
+// left there to browse.
+func folderURL(outputFolder string) string {
+	if info, err := os.Stat(outputFolder); err != nil || !info.IsDir() {
+		return ""
+	}
+	return "/files/" + url.PathEscape(filepath.Base(outputFolder)) + "/"
+}
@@ -1,13 +1,18 @@
 package handlers
 
 import (
-	"fmt"
+	"bufio"
+	"encoding/json"
 	"generate-code/service"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 func Index(c *fiber.Ctx) error {
@@ -15,26 +20,194 @@ func Index(c *fiber.Ctx) error {
 }
 
 func Upload(c *fiber.Ctx) error {
-	file, err := c.FormFile("file")
+	if boolFormValue(c, "stream") {
+		if status, err := streamUpload(c); err != nil {
+			return uploadError(c, status, err)
+		}
+		return nil
+	}
+
+	result, outputFolder, status, err := processUpload(c)
+	if err != nil {
+		return uploadError(c, status, err)
+	}
+
+	data := fiber.Map{
+		"JobID":        c.GetRespHeader("X-Job-ID"),
+		"Result":       result,
+		"OutputFolder": outputFolder,
+		"ZipFilename":  result.ZipFilename,
+	}
+	// without a zip the images are offered straight from the folder
+	if len(result.ZipFilenames) == 0 {
+		data["FolderURL"] = folderURL(outputFolder)
+	}
+	return c.Render("index", data)
+}
+
+// uploadError shows err above the form again, or answers with a JSON
+// error when the client asked for JSON instead of the page.
+func uploadError(c *fiber.Ctx, status int, err error) error {
+	if wantsJSON(c) {
+		return jsonError(c, status, err)
+	}
+	return c.Status(status).Render("index", fiber.Map{
+		"Error": localize(c, err),
+	})
+}
+
+// streamUpload answers with the generated zip directly, rendering every
+// QR in memory instead of under OUTPUT_BASE. The uploaded files are
+// removed once the zip is written, so nothing is kept on the server.
+// Errors are returned only while nothing has been written yet.
+func streamUpload(c *fiber.Ctx) (int, error) {
+	if status, err := acquireJobSlot(); err != nil {
+		return status, err
+	}
+	up, status, err := prepareUpload(c)
 	if err != nil {
-		return c.Render("index", fiber.Map{
-			"Error": "Tidak ada file diupload.",
-		})
+		releaseJobSlot()
+		return status, err
 	}
 
-	// Validate file size (max 5MB)
-	if file.Size > 5*1024*1024 {
-		return c.Render("index", fiber.Map{
-			"Error": "Ukuran file melebihi batas 5MB.",
-		})
+	// rows are read while the zip is written, so the upload is only
+	// removed once the stream is done with it
+	removeUpload := func() {
+		os.Remove(up.FilePath)
+		if up.Options.LogoPath != "" {
+			os.Remove(up.Options.LogoPath)
+		}
 	}
 
-	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if ext != ".xlsx" && ext != ".xls" && ext != ".csv" {
-		return c.Render("index", fiber.Map{
-			"Error": "Format file tidak didukung. Harap upload file Excel (.xlsx, .xls) atau CSV (.csv).",
-		})
+	stream, err := service.OpenZipStream(up.FilePath, up.Options)
+	if err != nil {
+		removeUpload()
+		slog.Warn("generation failed", "request_id", up.Options.RequestID, "error", err)
+		releaseJobSlot()
+		return errStatus(err), err
+	}
+
+	root := filepath.Base(up.OutputFolder)
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Attachment(stream.Filename(root))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer releaseJobSlot()
+		defer removeUpload()
+		defer stream.Close()
+		ctx, cancel := jobContext()
+		defer cancel()
+		if _, err := stream.WriteZip(ctx, w, root); err != nil {
+			slog.Warn("zip stream failed", "request_id", up.Options.RequestID, "error", err)
+		}
+		w.Flush()
+	})
+	return fiber.StatusOK, nil
+}
+
+// upload is a saved spreadsheet together with the options parsed from the
+// form, ready to be handed to service.RunGenerate.
+type upload struct {
+	FilePath     string
+	Filename     string // the sanitized name the file was uploaded under
+	OutputFolder string
+	Options      service.Options
+}
+
+// processUpload validates and saves the uploaded spreadsheet, then runs
+// the generator on it. On failure it returns the HTTP status that best
+// describes the problem alongside a user-facing error. Runs that get as
+// far as generating are recorded in the job history under the ID sent in
+// the X-Job-ID header.
+//
+// A request carrying an idempotency key already used by a running or
+// recent run gets that run's outcome instead of starting another one.
+func processUpload(c *fiber.Ctx) (*service.Result, string, int, error) {
+	key, err := idempotencyKey(c)
+	if err != nil {
+		return nil, "", fiber.StatusBadRequest, err
+	}
+	if key == "" {
+		return runUpload(c, uuid.NewString())
+	}
+	run, owner := claimKey(key)
+	if !owner {
+		<-run.done
+		c.Set("X-Job-ID", run.jobID)
+		c.Set("Idempotent-Replayed", "true")
+		return run.result, run.outputFolder, run.status, run.err
+	}
+	result, outputFolder, status, err := runUpload(c, run.jobID)
+	run.finish(result, outputFolder, status, err)
+	return result, outputFolder, status, err
+}
+
+// runUpload is processUpload for one run recorded under jobID.
+func runUpload(c *fiber.Ctx, jobID string) (*service.Result, string, int, error) {
+	if status, err := acquireJobSlot(); err != nil {
+		return nil, "", status, err
+	}
+	defer releaseJobSlot()
+
+	up, status, err := prepareUpload(c)
+	if err != nil {
+		return nil, "", status, err
+	}
+
+	c.Set("X-Job-ID", jobID)
+	ctx, cancel := jobContext()
+	defer cancel()
+	unlock := lockFolder(up.OutputFolder)
+	result, err := service.RunGenerate(ctx, up.FilePath, up.OutputFolder, up.Options)
+	unlock()
+	recordJob(jobID, up, result, err)
+	if err != nil {
+		slog.Warn("generation failed", "request_id", up.Options.RequestID, "error", err)
+		return nil, "", errStatus(err), err
+	}
+	cleanupUpload(up)
+
+	return result, up.OutputFolder, fiber.StatusOK, nil
+}
+
+// prepareUpload validates the multipart form and saves the uploaded files.
+// Instead of a file, the form may give a Google Sheets link in sheet_url,
+// which is downloaded as CSV. The saved files are removed again when the
+// rest of the form turns out invalid.
+func prepareUpload(c *fiber.Ctx) (_ *upload, _ int, err error) {
+	var filename, sheetURL string
+	file, err := c.FormFile("file")
+	if err != nil {
+		raw := strings.TrimSpace(c.FormValue("sheet_url"))
+		if raw == "" {
+			return nil, fiber.StatusBadRequest, errMsg("no_file")
+		}
+		exportURL, id, err := sheetCSVURL(raw)
+		if err != nil {
+			return nil, fiber.StatusBadRequest, err
+		}
+		sheetURL = exportURL
+		filename = service.SanitizeFilename("sheet-" + id[:min(len(id), 12)] + ".csv")
+	} else {
+		// Validate file size (MAX_UPLOAD_MB)
+		if file.Size > int64(maxUploadMB)<<20 {
+			return nil, fiber.StatusRequestEntityTooLarge, errTooLarge()
+		}
+
+		// Validate file extension
+		ext := strings.ToLower(filepath.Ext(file.Filename))
+		switch ext {
+		case ".xlsx", ".xls", ".ods", ".csv", ".tsv", ".txt":
+		default:
+			return nil, fiber.StatusBadRequest, errMsg("unsupported_format")
+		}
+		filename = service.SanitizeFilename(file.Filename)
+	}
+
+	var columns map[string]string
+	if raw := strings.TrimSpace(c.FormValue("column_map")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &columns); err != nil {
+			return nil, fiber.StatusBadRequest, errMsg("invalid_columns", err)
+		}
 	}
 
 	uploadFolder := os.Getenv("UPLOAD_FOLDER")
@@ -47,43 +220,325 @@ func Upload(c *fiber.Ctx) error {
 	}
 
 	if err := os.MkdirAll(uploadFolder, 0755); err != nil {
-		return c.Render("index", fiber.Map{
-			"error": fmt.Sprintf("Failed to create upload dir: %v", err),
-		})
+		return nil, fiber.StatusInternalServerError, errMsg("upload_dir_failed", err)
 	}
 
-	filename := service.SanitizeFilename(file.Filename)
-	filepathStr := filepath.Join(uploadFolder, filename)
+	// saved under a unique name, so concurrent uploads of the same file
+	// don't overwrite or remove each other's input
+	filepathStr, err := uploadPath(uploadFolder, filename)
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, errMsg("save_failed", err)
+	}
 
-	if err := c.SaveFile(file, filepathStr); err != nil {
-		return c.Render("index", fiber.Map{
-			"error": fmt.Sprintf("Failed to save file: %v", err),
-		})
+	// copied because a client-supplied ID aliases the request buffer,
+	// which is reused once the handler returns
+	requestID, _ := c.Locals("requestid").(string)
+	requestID = strings.Clone(requestID)
+
+	var logoPath string
+	defer func() {
+		if err != nil {
+			os.Remove(filepathStr)
+			if logoPath != "" {
+				os.Remove(logoPath)
+			}
+		}
+	}()
+
+	if sheetURL != "" {
+		slog.Info("fetching sheet", "request_id", requestID, "url", sheetURL)
+		if status, err := fetchSheet(sheetURL, filepathStr); err != nil {
+			return nil, status, err
+		}
+	} else {
+		slog.Info("upload received", "request_id", requestID, "file", filename, "size", file.Size)
+		if err := c.SaveFile(file, filepathStr); err != nil {
+			return nil, fiber.StatusInternalServerError, errMsg("save_failed", err)
+		}
+	}
+
+	if logo, err := c.FormFile("logo"); err == nil {
+		if strings.ToLower(filepath.Ext(logo.Filename)) != ".png" {
+			return nil, fiber.StatusBadRequest, errMsg("logo_not_png")
+		}
+		// each upload gets its own logo file, so concurrent jobs with
+		// the same logo name don't overwrite or remove each other's
+		logoPath, err = uploadPath(uploadFolder, "logo-"+service.SanitizeFilename(logo.Filename))
+		if err != nil {
+			return nil, fiber.StatusInternalServerError, errMsg("save_logo_failed", err)
+		}
+		if err := c.SaveFile(logo, logoPath); err != nil {
+			return nil, fiber.StatusInternalServerError, errMsg("save_logo_failed", err)
+		}
 	}
 
 	importName := strings.TrimSuffix(filename, filepath.Ext(filename))
 	outputFolder := filepath.Join(outputBase, importName)
+	// a named output folder is kept across uploads: new codes join the
+	// earlier ones, existing files are skipped and the zip covers them all
+	persistent := false
+	if raw := strings.TrimSpace(c.FormValue("output_name")); raw != "" {
+		name := service.SanitizeFolder(raw)
+		if name == "" {
+			return nil, fiber.StatusBadRequest, errMsg("invalid_folder", raw)
+		}
+		outputFolder = filepath.Join(outputBase, name)
+		persistent = true
+	}
 
-	result, err := service.RunGenerate(filepathStr, outputFolder)
+	scale, err := intFormValue(c, "scale")
 	if err != nil {
-		return c.Render("index", fiber.Map{
-			"Error": err.Error(),
-		})
+		return nil, fiber.StatusBadRequest, err
 	}
+	nikLength, err := intFormValue(c, "nik_length")
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	kkLength, err := intFormValue(c, "kk_length")
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	jpegQuality, err := intFormValue(c, "jpeg_quality")
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	supersample, err := intFormValue(c, "supersample")
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	// zip parts are sized in MB on the form, e.g. max_zip_mb=25
+	maxZipMB, err := intFormValue(c, "max_zip_mb")
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	padding, err := intFormValue(c, "padding")
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	// an empty border keeps the default, so zero has to be told apart
+	var border *int
+	if strings.TrimSpace(c.FormValue("border")) != "" {
+		n, err := intFormValue(c, "border")
+		if err != nil {
+			return nil, fiber.StatusBadRequest, err
+		}
+		border = &n
+	}
+	// the color picker always submits a value, so the gradient is only
+	// enabled by choosing a direction
+	var gradientColor string
+	if c.FormValue("gradient_direction") != "" {
+		gradientColor = c.FormValue("gradient_color")
+	}
+	eyeColor, eyeBorderColor := eyeFormColors(c)
 
-	return c.Render("index", fiber.Map{
-		"Result":       result,
-		"OutputFolder": outputFolder,
-		"ZipFilename":  result.ZipFilename,
-	})
+	// comma-separated sheets to combine, or "*" for all of them
+	var sheets []string
+	for _, sheet := range strings.Split(c.FormValue("sheets"), ",") {
+		if sheet = strings.TrimSpace(sheet); sheet != "" {
+			sheets = append(sheets, sheet)
+		}
+	}
+
+	pdfColumns, err := intFormValue(c, "pdf_columns")
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+	// comma-separated columns; "flat" drops the folders, empty keeps
+	// the default KECAMATAN/KELURAHAN layout
+	var folderLevels []string
+	switch raw := strings.TrimSpace(c.FormValue("folder_levels")); strings.ToLower(raw) {
+	case "":
+	case "flat":
+		folderLevels = []string{}
+	default:
+		for _, level := range strings.Split(raw, ",") {
+			folderLevels = append(folderLevels, strings.TrimSpace(level))
+		}
+	}
+	// comma-separated columns, one QR per column per row
+	var contentColumns []string
+	if raw := strings.TrimSpace(c.FormValue("content_columns")); raw != "" {
+		for _, column := range strings.Split(raw, ",") {
+			contentColumns = append(contentColumns, strings.TrimSpace(column))
+		}
+	}
+	// contact card fields mapped to columns, e.g. card_phone=NO HP
+	cardColumns := make(map[string]string)
+	for _, field := range []string{service.CardName, service.CardPhone, service.CardEmail, service.CardOrg} {
+		if column := strings.TrimSpace(c.FormValue("card_" + field)); column != "" {
+			cardColumns[field] = column
+		}
+	}
+	maxDimension, _ := strconv.Atoi(os.Getenv("MAX_IMAGE_DIMENSION"))
+	maxContentLength, _ := strconv.Atoi(os.Getenv("MAX_CONTENT_LENGTH"))
+	maxImageMB, _ := strconv.Atoi(os.Getenv("MAX_IMAGE_MB"))
+	maxOutputMB, _ := strconv.Atoi(os.Getenv("MAX_OUTPUT_MB"))
+	maxRows, _ := strconv.Atoi(os.Getenv("MAX_ROWS"))
+	writeRetries, _ := strconv.Atoi(os.Getenv("WRITE_RETRIES"))
+	workers, _ := strconv.Atoi(os.Getenv("MAX_WORKERS"))
+	minModulePixels, _ := strconv.Atoi(os.Getenv("MIN_MODULE_PIXELS"))
+	// octal permissions, e.g. OUTPUT_DIR_MODE=775 OUTPUT_FILE_MODE=664
+	dirMode, _ := strconv.ParseUint(os.Getenv("OUTPUT_DIR_MODE"), 8, 32)
+	fileMode, _ := strconv.ParseUint(os.Getenv("OUTPUT_FILE_MODE"), 8, 32)
+
+	opts := service.Options{
+		Format:            c.FormValue("format"),
+		FgColor:           c.FormValue("fg_color"),
+		BgColor:           c.FormValue("bg_color"),
+		TransparentBg:     boolFormValue(c, "transparent_bg"),
+		StrictISO:         boolFormValue(c, "strict_iso"),
+		Columns:           columns,
+		ContentColumns:    contentColumns,
+		Card:              c.FormValue("card"),
+		CardColumns:       cardColumns,
+		CardPhoneFormat:   c.FormValue("card_phone_format"),
+		PNGCompression:    c.FormValue("png_compression"),
+		WiFi:              boolFormValue(c, "wifi"),
+		ContentMode:       c.FormValue("content_mode"),
+		ContentPrefix:     c.FormValue("content_prefix"),
+		ContentFallback:   strings.TrimSpace(c.FormValue("content_fallback")),
+		ContentSuffix:     c.FormValue("content_suffix"),
+		ECC:               c.FormValue("ecc"),
+		Delimiter:         c.FormValue("delimiter"),
+		Charset:           c.FormValue("charset"),
+		Sheet:             c.FormValue("sheet"),
+		Sheets:            sheets,
+		LogoPath:          logoPath,
+		Scale:             scale,
+		MinModulePixels:   minModulePixels,
+		StrictModuleSize:  boolFormValue(c, "strict_module_size"),
+		Border:            border,
+		Padding:           padding,
+		MaxDimension:      maxDimension,
+		MaxContentLength:  maxContentLength,
+		MaxImageMB:        maxImageMB,
+		MaxOutputMB:       maxOutputMB,
+		MaxRows:           maxRows,
+		WriteRetries:      writeRetries,
+		Workers:           workers,
+		DirMode:           os.FileMode(dirMode),
+		FileMode:          os.FileMode(fileMode),
+		JPEGQuality:       jpegQuality,
+		ZipMode:           c.FormValue("zip_mode"),
+		ZipName:           strings.TrimSpace(c.FormValue("zip_name")),
+		ZipCompression:    c.FormValue("zip_compression"),
+		MaxZipBytes:       int64(maxZipMB) << 20,
+		FilenameTemplate:  strings.TrimSpace(c.FormValue("filename_template")),
+		FolderLevels:      folderLevels,
+		FolderLayout:      c.FormValue("folder_layout"),
+		FolderPlaceholder: strings.TrimSpace(c.FormValue("folder_placeholder")),
+		Label:             strings.TrimSpace(c.FormValue("label")),
+		ShowContent:       boolFormValue(c, "show_content"),
+		ModuleStyle:       c.FormValue("module_style"),
+		Supersample:       supersample,
+		GradientColor:     gradientColor,
+		EyeColor:          eyeColor,
+		EyeBorderColor:    eyeBorderColor,
+		GradientDirection: c.FormValue("gradient_direction"),
+		ValidateOnly:      boolFormValue(c, "validate_only"),
+		FailFast:          boolFormValue(c, "fail_fast"),
+		DedupContent:      boolFormValue(c, "dedup"),
+		Overwrite:         boolFormValue(c, "overwrite"),
+		Verify:            boolFormValue(c, "verify"),
+		PNGMetadata:       boolFormValue(c, "png_metadata"),
+		SourceName:        filename,
+		PDF:               boolFormValue(c, "pdf"),
+		PDFColumns:        pdfColumns,
+		Manifest:          boolFormValue(c, "manifest"),
+		FileTree:          boolFormValue(c, "file_tree"),
+		SkipZip:           boolFormValue(c, "skip_zip"),
+		Cleanup:           cleanupEnabled() && c.FormValue("zip_mode") != service.ZipNone && !boolFormValue(c, "skip_zip") && !persistent,
+		RequestID:         requestID,
+		Lang:              requestLang(c),
+		NIKLength:         nikLength,
+		StrictNIK:         boolFormValue(c, "strict_nik"),
+		KKLength:          kkLength,
+	}
+
+	return &upload{
+		FilePath:     filepathStr,
+		Filename:     filename,
+		OutputFolder: outputFolder,
+		Options:      opts,
+	}, fiber.StatusOK, nil
+}
+
+// uploadPath creates an empty file in dir for an upload called name and
+// returns its path. A random prefix keeps uploads of the same name apart.
+func uploadPath(dir, name string) (string, error) {
+	f, err := os.CreateTemp(dir, "*-"+name)
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// intFormValue parses an optional integer form field; empty means zero.
+func intFormValue(c *fiber.Ctx, key string) (int, error) {
+	raw := strings.TrimSpace(c.FormValue(key))
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errMsg("invalid_value", key, raw)
+	}
+	return n, nil
+}
+
+// eyeFormColors reads the finder pattern colors. Like the gradient they
+// need enabling, here with the eye_colors checkbox, since color pickers
+// always submit a value.
+func eyeFormColors(c *fiber.Ctx) (string, string) {
+	if !boolFormValue(c, "eye_colors") {
+		return "", ""
+	}
+	return c.FormValue("eye_color"), c.FormValue("eye_border_color")
+}
+
+// boolFormValue reports whether a checkbox-style form field is set.
+func boolFormValue(c *fiber.Ctx, key string) bool {
+	switch strings.ToLower(strings.TrimSpace(c.FormValue(key))) {
+	case "1", "true", "on", "yes":
+		return true
+	}
+	return false
 }
 
 func Download(c *fiber.Ctx) error {
-	filename := c.Params("filename")
 	outputBase := os.Getenv("OUTPUT_BASE")
 	if outputBase == "" {
 		outputBase = "./qr_output"
 	}
-	filepath := filepath.Join(outputBase, filename)
-	return c.Download(filepath)
+
+	path, status, err := resolveOutputFile(outputBase, c.Params("filename"))
+	if err != nil {
+		return c.Status(status).SendString(localize(c, err))
+	}
+	return c.Download(path)
+}
+
+// resolveOutputFile maps a file name taken from the URL to a path directly
+// inside outputBase. Names containing separators or dot segments, in raw or
+// percent-encoded form, are rejected so requests cannot escape the folder.
+func resolveOutputFile(outputBase, name string) (string, int, error) {
+	decoded, err := url.PathUnescape(name)
+	if err != nil {
+		return "", fiber.StatusBadRequest, errMsg("invalid_file_name")
+	}
+	if decoded == "" || decoded == "." || decoded == ".." ||
+		strings.ContainsAny(decoded, `/\`) || strings.Contains(decoded, "..") {
+		return "", fiber.StatusBadRequest, errMsg("invalid_file_name")
+	}
+
+	base, err := filepath.Abs(outputBase)
+	if err != nil {
+		return "", fiber.StatusInternalServerError, err
+	}
+	path := filepath.Join(base, decoded)
+	if filepath.Dir(path) != base {
+		return "", fiber.StatusForbidden, errMsg("access_denied")
+	}
+	return path, fiber.StatusOK, nil
 }
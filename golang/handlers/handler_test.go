@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestResolveOutputFile(t *testing.T) {
+	base := t.TempDir()
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"batch.zip", fiber.StatusOK},
+		{"batch-2024.part1.zip", fiber.StatusOK},
+		// a double-encoded name is decoded once and stays a plain name
+		{"%252e%252e%252fmain.go", fiber.StatusOK},
+
+		{"", fiber.StatusBadRequest},
+		{".", fiber.StatusBadRequest},
+		{"..", fiber.StatusBadRequest},
+		{"../main.go", fiber.StatusBadRequest},
+		{"../../etc/passwd", fiber.StatusBadRequest},
+		{"sub/../../main.go", fiber.StatusBadRequest},
+		{"%2e%2e%2fmain.go", fiber.StatusBadRequest},
+		{"..%2fmain.go", fiber.StatusBadRequest},
+		{"%2E%2E%2F%2E%2E%2Fmain.go", fiber.StatusBadRequest},
+		{"%2e%2e", fiber.StatusBadRequest},
+		{"/etc/passwd", fiber.StatusBadRequest},
+		{"%2fetc%2fpasswd", fiber.StatusBadRequest},
+		{`C:\Windows\win.ini`, fiber.StatusBadRequest},
+		{`..\main.go`, fiber.StatusBadRequest},
+		{"..%5cmain.go", fiber.StatusBadRequest},
+		{"%zz", fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		path, status, err := resolveOutputFile(base, tt.name)
+		if status != tt.status {
+			t.Errorf("resolveOutputFile(%q) = %q, %d, %v; want status %d", tt.name, path, status, err, tt.status)
+			continue
+		}
+		if status == fiber.StatusOK && filepath.Dir(path) != base {
+			t.Errorf("resolveOutputFile(%q) = %q, outside %s", tt.name, path, base)
+		}
+	}
+}
+
+func TestDownloadTraversal(t *testing.T) {
+	base := t.TempDir()
+	t.Setenv("OUTPUT_BASE", base)
+	if err := os.WriteFile(filepath.Join(base, "batch.zip"), []byte("zip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/download/:filename", Download)
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/download/batch.zip", fiber.StatusOK},
+		{"/download/..%2f..%2fmain.go", fiber.StatusBadRequest},
+		{"/download/%2e%2e%2fmain.go", fiber.StatusBadRequest},
+		{"/download/..%5cmain.go", fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.target, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestUploadPathUnique(t *testing.T) {
+	dir := t.TempDir()
+	first, err := uploadPath(dir, "logo-kab.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := uploadPath(dir, "logo-kab.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("two uploads of logo-kab.png share %s", first)
+	}
+	for _, path := range []string{first, second} {
+		if filepath.Dir(path) != dir || !strings.HasSuffix(path, "-logo-kab.png") {
+			t.Errorf("upload path %s, want <random>-logo-kab.png in %s", path, dir)
+		}
+	}
+}
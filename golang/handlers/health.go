@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+// Healthz returns a readiness probe reporting whether the templates are
+// loaded and the views, upload and output directories are writable. Any
+// failing check makes it respond 503.
+func Healthz(engine *html.Engine) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		uploadFolder := os.Getenv("UPLOAD_FOLDER")
+		if uploadFolder == "" {
+			uploadFolder = "./uploads"
+		}
+		outputBase := os.Getenv("OUTPUT_BASE")
+		if outputBase == "" {
+			outputBase = "./qr_output"
+		}
+
+		checks := fiber.Map{
+			"templates": checkResult(checkTemplates(engine)),
+			"views":     checkResult(checkWritable(engine.Directory)),
+			"uploads":   checkResult(checkWritable(uploadFolder)),
+			"qr_output": checkResult(checkWritable(outputBase)),
+		}
+
+		status, code := "ok", fiber.StatusOK
+		for _, v := range checks {
+			if v != "ok" {
+				status, code = "error", fiber.StatusServiceUnavailable
+			}
+		}
+		return c.Status(code).JSON(fiber.Map{
+			"status": status,
+			"checks": checks,
+		})
+	}
+}
+
+func checkResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// checkTemplates makes sure the engine parsed the index page.
+func checkTemplates(engine *html.Engine) error {
+	if err := engine.Load(); err != nil {
+		return err
+	}
+	engine.Mutex.RLock()
+	defer engine.Mutex.RUnlock()
+	if engine.Templates == nil || engine.Templates.Lookup("index") == nil {
+		return errors.New("template index not loaded")
+	}
+	return nil
+}
+
+// checkWritable creates the directory if needed and writes a throwaway
+// file into it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".healthz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
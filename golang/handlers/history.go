@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"generate-code/service"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxJobHistory caps how many finished runs are remembered; the oldest
+// are dropped first.
+const maxJobHistory = 100
+
+// JobRecord is a finished generation run, kept so its archives can be
+// downloaded again after the original response is gone.
+type JobRecord struct {
+	ID       string      `json:"id"`
+	File     string      `json:"file"`
+	Finished time.Time   `json:"finished"`
+	Error    string      `json:"error,omitempty"`
+	Result   *JobSummary `json:"result,omitempty"`
+	// Folder is the run's output folder under OUTPUT_BASE, which
+	// /qr/:nik serves single images from while it exists.
+	Folder string `json:"folder,omitempty"`
+	// FilenameTemplate is the template the run named its images with,
+	// empty for the default, so /qr/:nik can find them again.
+	FilenameTemplate string `json:"filename_template,omitempty"`
+	// Downloads are the URLs of the run's zips and PDF that still exist;
+	// it is filled in when the record is served.
+	Downloads []string `json:"downloads"`
+}
+
+// JobSummary is what the history keeps of a run's Result: the totals and
+// the files it produced. The per-row outcomes and error messages are left
+// out, so records stay small however large the spreadsheet was.
+type JobSummary struct {
+	Generated    int      `json:"generated"`
+	Skipped      int      `json:"skipped"`
+	Invalid      int      `json:"invalid"`
+	Valid        int      `json:"valid"`
+	Duplicates   int      `json:"duplicates"`
+	Failed       int      `json:"failed"` // rows in Result.Errors
+	ZipFilenames []string `json:"zip_filenames"`
+	PDFFilename  string   `json:"pdf_filename,omitempty"`
+	BytesWritten int64    `json:"bytes_written"`
+	DurationMs   int64    `json:"duration_ms"`
+}
+
+func summarize(result *service.Result) *JobSummary {
+	if result == nil {
+		return nil
+	}
+	return &JobSummary{
+		Generated:    result.Generated,
+		Skipped:      result.Skipped,
+		Invalid:      result.Invalid,
+		Valid:        result.Valid,
+		Duplicates:   result.Duplicates,
+		Failed:       len(result.Errors),
+		ZipFilenames: result.ZipFilenames,
+		PDFFilename:  result.PDFFilename,
+		BytesWritten: result.BytesWritten,
+		DurationMs:   result.DurationMs,
+	}
+}
+
+// history is the job store. When path is set every change is written to
+// it as JSON, so records survive restarts.
+var history = struct {
+	sync.Mutex
+	records []JobRecord // oldest first
+	path    string
+}{}
+
+// LoadJobHistory makes the job store persistent in the JSON file at path,
+// reading the records saved there by earlier runs. A missing file starts
+// an empty history.
+func LoadJobHistory(path string) error {
+	history.Lock()
+	defer history.Unlock()
+	history.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &history.records)
+}
+
+// recordJob adds a finished run to the store.
+func recordJob(id string, up *upload, result *service.Result, err error) {
+	rec := JobRecord{
+		ID:               id,
+		File:             up.Filename,
+		Finished:         time.Now(),
+		Result:           summarize(result),
+		Folder:           filepath.Base(up.OutputFolder),
+		FilenameTemplate: up.Options.FilenameTemplate,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	history.Lock()
+	defer history.Unlock()
+	history.records = append(history.records, rec)
+	if n := len(history.records) - maxJobHistory; n > 0 {
+		history.records = append([]JobRecord(nil), history.records[n:]...)
+	}
+	if history.path == "" {
+		return
+	}
+	if err := saveJobHistory(); err != nil {
+		slog.Warn("failed to save job history", "path", history.path, "error", err)
+	}
+}
+
+// saveJobHistory writes the records to history.path through a temporary
+// file, so a crash never leaves it half written. Callers must hold the
+// history lock.
+func saveJobHistory() error {
+	data, err := json.Marshal(history.records)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(history.path), ".jobs-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), history.path)
+}
+
+// withDownloads returns rec with the download URLs of the files it
+// produced that haven't been swept or deleted yet.
+func withDownloads(rec JobRecord) JobRecord {
+	rec.Downloads = []string{}
+	if rec.Result == nil {
+		return rec
+	}
+	outputBase := os.Getenv("OUTPUT_BASE")
+	if outputBase == "" {
+		outputBase = "./qr_output"
+	}
+	names := append([]string{}, rec.Result.ZipFilenames...)
+	if rec.Result.PDFFilename != "" {
+		names = append(names, rec.Result.PDFFilename)
+	}
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(outputBase, name)); err == nil {
+			rec.Downloads = append(rec.Downloads, "/download/"+name)
+		}
+	}
+	return rec
+}
+
+// ListJobs returns the remembered runs, most recent first.
+func ListJobs(c *fiber.Ctx) error {
+	history.Lock()
+	records := make([]JobRecord, 0, len(history.records))
+	for i := len(history.records) - 1; i >= 0; i-- {
+		records = append(records, history.records[i])
+	}
+	history.Unlock()
+
+	for i := range records {
+		records[i] = withDownloads(records[i])
+	}
+	return c.JSON(records)
+}
+
+// GetJob returns a single remembered run.
+func GetJob(c *fiber.Ctx) error {
+	id := c.Params("id")
+	history.Lock()
+	var rec *JobRecord
+	for i := range history.records {
+		if history.records[i].ID == id {
+			found := history.records[i]
+			rec = &found
+			break
+		}
+	}
+	history.Unlock()
+
+	if rec == nil {
+		return jsonError(c, fiber.StatusNotFound, errMsg("job_not_found"))
+	}
+	return c.JSON(withDownloads(*rec))
+}
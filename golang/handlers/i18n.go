@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"errors"
+	"generate-code/service"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultLang is used for requests asking for no supported language; the
+// web UI is in Indonesian.
+const DefaultLang = service.LangIndonesian
+
+// messages are the texts handlers answer with, by key.
+var messages = service.Catalog{
+	"unauthorized": {
+		service.LangIndonesian: "API key tidak valid atau tidak ada.",
+		service.LangEnglish:    "Invalid or missing API key.",
+	},
+	"no_file": {
+		service.LangIndonesian: "Tidak ada file diupload.",
+		service.LangEnglish:    "No file uploaded.",
+	},
+	"unsupported_format": {
+		service.LangIndonesian: "Format file tidak didukung. Harap upload file Excel (.xlsx, .xls), OpenDocument (.ods), CSV (.csv), TSV (.tsv) atau teks berpemisah (.txt).",
+		service.LangEnglish:    "Unsupported file format. Please upload an Excel (.xlsx, .xls), OpenDocument (.ods), CSV (.csv), TSV (.tsv) or delimited text (.txt) file.",
+	},
+	"invalid_columns": {
+		service.LangIndonesian: "Pemetaan kolom tidak valid: %v",
+		service.LangEnglish:    "Invalid column mapping: %v",
+	},
+	"upload_dir_failed": {
+		service.LangIndonesian: "Gagal membuat folder upload: %v",
+		service.LangEnglish:    "Failed to create upload dir: %v",
+	},
+	"save_failed": {
+		service.LangIndonesian: "Gagal menyimpan file: %v",
+		service.LangEnglish:    "Failed to save file: %v",
+	},
+	"logo_not_png": {
+		service.LangIndonesian: "Logo harus berupa file PNG.",
+		service.LangEnglish:    "The logo must be a PNG file.",
+	},
+	"save_logo_failed": {
+		service.LangIndonesian: "Gagal menyimpan logo: %v",
+		service.LangEnglish:    "Failed to save logo: %v",
+	},
+	"invalid_folder": {
+		service.LangIndonesian: "Nama folder output tidak valid: %s",
+		service.LangEnglish:    "Invalid output folder name: %s",
+	},
+	"invalid_value": {
+		service.LangIndonesian: "Nilai %s tidak valid: %s",
+		service.LangEnglish:    "Invalid %s value: %s",
+	},
+	"invalid_file_name": {
+		service.LangIndonesian: "Nama file tidak valid.",
+		service.LangEnglish:    "Invalid file name.",
+	},
+	"access_denied": {
+		service.LangIndonesian: "Akses ditolak.",
+		service.LangEnglish:    "Access denied.",
+	},
+	"idempotency_key_too_long": {
+		service.LangIndonesian: "Idempotency key terlalu panjang.",
+		service.LangEnglish:    "Idempotency key is too long.",
+	},
+	"busy": {
+		service.LangIndonesian: "Server sedang memproses terlalu banyak file. Coba lagi sebentar lagi.",
+		service.LangEnglish:    "The server is processing too many files. Try again shortly.",
+	},
+	"shutting_down": {
+		service.LangIndonesian: "Server sedang dimatikan. Coba lagi sebentar lagi.",
+		service.LangEnglish:    "The server is shutting down. Try again shortly.",
+	},
+	"too_large": {
+		service.LangIndonesian: "Ukuran file melebihi batas %dMB.",
+		service.LangEnglish:    "The file exceeds the %dMB limit.",
+	},
+	"job_not_found": {
+		service.LangIndonesian: "Job tidak ditemukan.",
+		service.LangEnglish:    "Job not found.",
+	},
+	"nik_not_digits": {
+		service.LangIndonesian: "NIK harus berupa angka.",
+		service.LangEnglish:    "The NIK must be digits only.",
+	},
+	"job_folder_not_found": {
+		service.LangIndonesian: "Folder output job tidak ditemukan.",
+		service.LangEnglish:    "The job's output folder was not found.",
+	},
+	"qr_not_found": {
+		service.LangIndonesian: "QR untuk NIK %s tidak ditemukan.",
+		service.LangEnglish:    "No QR found for NIK %s.",
+	},
+	"invalid_sheets_url": {
+		service.LangIndonesian: "URL Google Sheets tidak valid. Gunakan tautan https://docs.google.com/spreadsheets/...",
+		service.LangEnglish:    "Invalid Google Sheets URL. Use a https://docs.google.com/spreadsheets/... link.",
+	},
+	"sheets_timeout": {
+		service.LangIndonesian: "Google Sheets tidak merespons dalam %s.",
+		service.LangEnglish:    "Google Sheets did not respond within %s.",
+	},
+	"sheets_fetch_failed": {
+		service.LangIndonesian: "Gagal mengambil Google Sheets: %v",
+		service.LangEnglish:    "Failed to fetch Google Sheets: %v",
+	},
+	"sheets_status": {
+		service.LangIndonesian: "Google Sheets mengembalikan status %d. Pastikan sheet dapat diakses publik atau dipublikasikan ke web.",
+		service.LangEnglish:    "Google Sheets returned status %d. Make sure the sheet is public or published to the web.",
+	},
+	"sheets_not_csv": {
+		service.LangIndonesian: "Google Sheets tidak mengembalikan CSV. Pastikan sheet dapat diakses publik atau dipublikasikan ke web.",
+		service.LangEnglish:    "Google Sheets did not return CSV. Make sure the sheet is public or published to the web.",
+	},
+	"websocket_required": {
+		service.LangIndonesian: "Endpoint ini membutuhkan koneksi WebSocket.",
+		service.LangEnglish:    "This endpoint requires a WebSocket connection.",
+	},
+	"not_a_zip": {
+		service.LangIndonesian: "Bukan file zip.",
+		service.LangEnglish:    "Not a zip file.",
+	},
+	"zip_not_found": {
+		service.LangIndonesian: "Zip tidak ditemukan.",
+		service.LangEnglish:    "Zip not found.",
+	},
+	"page_not_found": {
+		service.LangIndonesian: "Halaman tidak ditemukan.",
+		service.LangEnglish:    "Page not found.",
+	},
+	"internal_error": {
+		service.LangIndonesian: "Terjadi kesalahan pada server.",
+		service.LangEnglish:    "Internal server error.",
+	},
+}
+
+// messageError is an error answered in the language of the request that
+// receives it. Error gives the DefaultLang text, for logs.
+type messageError struct {
+	key  string
+	args []any
+}
+
+func errMsg(key string, args ...any) error {
+	return &messageError{key: key, args: args}
+}
+
+func (e *messageError) Error() string {
+	return messages.Text(DefaultLang, e.key, e.args...)
+}
+
+// localize returns the text of err for the client of c. Errors from the
+// service are passed on as they are.
+func localize(c *fiber.Ctx, err error) string {
+	var me *messageError
+	if errors.As(err, &me) {
+		return messages.Text(requestLang(c), me.key, me.args...)
+	}
+	return err.Error()
+}
+
+// requestLang picks the language for the client of c: the lang form or
+// query field, then the first supported language in Accept-Language, in
+// the order listed, then DefaultLang.
+func requestLang(c *fiber.Ctx) string {
+	if lang := catalogLang(c.FormValue("lang")); lang != "" {
+		return lang
+	}
+	for _, tag := range strings.Split(c.Get(fiber.HeaderAcceptLanguage), ",") {
+		tag, _, _ = strings.Cut(tag, ";")
+		tag, _, _ = strings.Cut(strings.TrimSpace(tag), "-")
+		if lang := catalogLang(tag); lang != "" {
+			return lang
+		}
+	}
+	return DefaultLang
+}
+
+// catalogLang returns the language constant matching tag, or "". The
+// constant is returned rather than tag, which aliases the request buffer
+// and must not outlive the handler in Options.Lang.
+func catalogLang(tag string) string {
+	switch strings.ToLower(strings.TrimSpace(tag)) {
+	case service.LangEnglish:
+		return service.LangEnglish
+	case service.LangIndonesian:
+		return service.LangIndonesian
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"generate-code/service"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// maxIdempotencyKeyLen bounds the keys kept in the job registry.
+const maxIdempotencyKeyLen = 255
+
+// keyedRun is an upload started under an idempotency key. Requests
+// repeating the key wait on done and answer with the same outcome.
+type keyedRun struct {
+	key   string
+	jobID string
+	done  chan struct{}
+
+	// set before done is closed
+	result       *service.Result
+	outputFolder string
+	status       int
+	err          error
+	finished     time.Time
+}
+
+// idempotencyKey reads the key from the Idempotency-Key header or the
+// idempotency_key form field; empty means the request has none.
+func idempotencyKey(c *fiber.Ctx) (string, error) {
+	key := strings.TrimSpace(c.Get("Idempotency-Key"))
+	if key == "" {
+		key = strings.TrimSpace(c.FormValue("idempotency_key"))
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		return "", errMsg("idempotency_key_too_long")
+	}
+	// copied because the key outlives the request buffer it aliases, in
+	// the registry and in the run
+	return strings.Clone(key), nil
+}
+
+// claimKey returns the run registered under key. owner is true when the
+// run was just created and the caller must start it and call finish.
+// Finished runs are forgotten after jobRetention.
+func claimKey(key string) (run *keyedRun, owner bool) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	for k, r := range jobs.byKey {
+		if !r.finished.IsZero() && time.Since(r.finished) > jobRetention {
+			delete(jobs.byKey, k)
+		}
+	}
+	if run, ok := jobs.byKey[key]; ok {
+		return run, false
+	}
+	run = &keyedRun{key: key, jobID: uuid.NewString(), done: make(chan struct{})}
+	jobs.byKey[key] = run
+	return run, true
+}
+
+// finish hands the outcome to the waiting requests. Failed runs are
+// dropped from the registry right away, so a retry starts afresh.
+func (r *keyedRun) finish(result *service.Result, outputFolder string, status int, err error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	r.result, r.outputFolder, r.status, r.err = result, outputFolder, status, err
+	r.finished = time.Now()
+	close(r.done)
+	if err != nil {
+		delete(jobs.byKey, r.key)
+	}
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"generate-code/service"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// jobRetention is how long finished jobs stay queryable.
+const jobRetention = time.Hour
+
+// Progress streams coalesce updates: an event goes out once batchRows
+// more rows are done or batchInterval has passed with anything new.
+// The defaults send every update.
+var (
+	batchRows     = 1
+	batchInterval time.Duration
+)
+
+// SetProgressBatch sets how progress streams batch updates on large
+// jobs: at most one event per rows rows (zero for no row trigger) and
+// per interval. It must be called before the server starts.
+func SetProgressBatch(rows int, interval time.Duration) {
+	batchRows, batchInterval = max(rows, 0), max(interval, 0)
+	if batchRows == 0 && batchInterval == 0 {
+		batchRows = 1
+	}
+}
+
+// errStreamGone reports that a stream's client went away.
+var errStreamGone = errors.New("client disconnected")
+
+// Job tracks a background generation run.
+type Job struct {
+	ID string
+
+	mu       sync.Mutex
+	progress service.Progress
+	rows     []service.RowResult // in completion order, for /ws/jobs
+	result   *service.Result
+	err      error
+	done     bool
+	finished time.Time
+	changed  chan struct{} // closed and replaced on every update
+	cancel   context.CancelFunc
+	sockets  int // clients following on /ws/jobs
+}
+
+type jobEvent struct {
+	Progress service.Progress `json:"progress"`
+	Done     bool             `json:"done"`
+	Result   *service.Result  `json:"result,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+var jobs = struct {
+	sync.Mutex
+	byID  map[string]*Job
+	byKey map[string]*keyedRun // uploads by idempotency key
+}{byID: make(map[string]*Job), byKey: make(map[string]*keyedRun)}
+
+// newJob registers a job that cancel stops. cancel is set before the job
+// is published, so a socket that finds the job can always cancel it.
+func newJob(cancel context.CancelFunc) *Job {
+	job := &Job{
+		ID:      uuid.NewString(),
+		changed: make(chan struct{}),
+		cancel:  cancel,
+	}
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	for id, j := range jobs.byID {
+		j.mu.Lock()
+		expired := j.done && time.Since(j.finished) > jobRetention
+		j.mu.Unlock()
+		if expired {
+			delete(jobs.byID, id)
+		}
+	}
+	jobs.byID[job.ID] = job
+	return job
+}
+
+func getJob(id string) *Job {
+	jobs.Lock()
+	defer jobs.Unlock()
+	return jobs.byID[id]
+}
+
+// notify wakes everyone waiting on the job. Callers must hold j.mu.
+func (j *Job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *Job) setProgress(p service.Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = p
+	j.notify()
+}
+
+func (j *Job) addRow(r service.RowResult) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.rows = append(j.rows, r)
+	j.notify()
+}
+
+func (j *Job) finish(result *service.Result, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.result = result
+	j.err = err
+	j.done = true
+	j.finished = time.Now()
+	j.notify()
+}
+
+// snapshot returns the current state as an event plus a channel that is
+// closed on the next update.
+func (j *Job) snapshot() (jobEvent, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ev := jobEvent{Progress: j.progress, Done: j.done, Result: j.result}
+	if j.err != nil {
+		ev.Error = j.err.Error()
+	}
+	return ev, j.changed
+}
+
+// rowsSince is snapshot plus the rows finished after the first n.
+func (j *Job) rowsSince(n int) ([]service.RowResult, jobEvent, <-chan struct{}) {
+	ev, changed := j.snapshot()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.rows[n:], ev, changed
+}
+
+// waitBatch blocks until the job has a batch worth sending after the
+// processed rows already sent at last, or has finished. Meanwhile
+// keepAlive ticks call ping; a closed gone returns errStreamGone.
+func (j *Job) waitBatch(processed int, last time.Time, gone <-chan struct{}, keepAlive <-chan time.Time, ping func() error) error {
+	var deadline <-chan time.Time
+	if batchInterval > 0 {
+		timer := time.NewTimer(time.Until(last.Add(batchInterval)))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		ev, changed := j.snapshot()
+		pending := ev.Progress.Processed - processed
+		if ev.Done ||
+			(batchRows > 0 && pending >= batchRows) ||
+			(batchInterval > 0 && pending > 0 && time.Since(last) >= batchInterval) {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			deadline = nil
+		case <-gone:
+			return errStreamGone
+		case <-keepAlive:
+			if err := ping(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// StartJob accepts the same multipart upload as Upload, starts generation
+// in the background and returns the job ID to follow via /progress/:jobid.
+func StartJob(c *fiber.Ctx) error {
+	if status, err := acquireJobSlot(); err != nil {
+		return jsonError(c, status, err)
+	}
+	up, status, err := prepareUpload(c)
+	if err != nil {
+		releaseJobSlot()
+		return jsonError(c, status, err)
+	}
+
+	ctx, cancel := jobContext()
+	job := newJob(cancel)
+	up.Options.OnProgress = job.setProgress
+	up.Options.OnRow = job.addRow
+	go func() {
+		defer releaseJobSlot()
+		defer cancel()
+		unlock := lockFolder(up.OutputFolder)
+		result, err := service.RunGenerate(ctx, up.FilePath, up.OutputFolder, up.Options)
+		unlock()
+		if err == nil {
+			cleanupUpload(up)
+		} else {
+			slog.Warn("generation failed", "request_id", up.Options.RequestID, "job_id", job.ID, "error", err)
+		}
+		recordJob(job.ID, up, result, err)
+		job.finish(result, err)
+	}()
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"job_id": job.ID,
+	})
+}
+
+// Progress streams a job's running totals as Server-Sent Events. A
+// "progress" event is sent on every batch of updates (see
+// SetProgressBatch) and a final "done" event carries the Result (or the
+// error) before the stream closes.
+func Progress(c *fiber.Ctx) error {
+	job := getJob(c.Params("jobid"))
+	if job == nil {
+		return jsonError(c, fiber.StatusNotFound, errMsg("job_not_found"))
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+
+		// Comment lines keep proxies from closing idle streams and
+		// surface disconnected clients via Flush errors.
+		ping := func() error {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return w.Flush()
+		}
+		for {
+			ev, _ := job.snapshot()
+			name := "progress"
+			if ev.Done {
+				name = "done"
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+			if err := w.Flush(); err != nil || ev.Done {
+				return
+			}
+			if err := job.waitBatch(ev.Progress.Processed, time.Now(), nil, keepAlive.C, ping); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
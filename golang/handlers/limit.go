@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"generate-code/service"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultMaxConcurrentJobs is used when no positive limit is configured.
+const DefaultMaxConcurrentJobs = 4
+
+var (
+	errBusy         = errMsg("busy")
+	errShuttingDown = errMsg("shutting_down")
+)
+
+// jobSlots bounds how many generation runs may be active at once. A slot
+// is held for the whole run, including background jobs and streamed
+// responses that outlive their handler.
+var jobSlots = make(chan struct{}, DefaultMaxConcurrentJobs)
+
+// SetMaxConcurrentJobs sets the number of generation runs allowed at the
+// same time. It must be called before the server starts.
+func SetMaxConcurrentJobs(n int) {
+	if n <= 0 {
+		n = DefaultMaxConcurrentJobs
+	}
+	jobSlots = make(chan struct{}, n)
+}
+
+// active counts the runs holding a slot so shutdown can wait for them.
+// Once draining is set no new run may start.
+var active = struct {
+	sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+}{}
+
+// acquireJobSlot reserves a slot without waiting. When none is free, or
+// the server is shutting down, it returns the status and error to answer
+// with.
+func acquireJobSlot() (int, error) {
+	active.Lock()
+	defer active.Unlock()
+	if active.draining {
+		return fiber.StatusServiceUnavailable, errShuttingDown
+	}
+	select {
+	case jobSlots <- struct{}{}:
+		active.wg.Add(1)
+		return fiber.StatusOK, nil
+	default:
+		return fiber.StatusTooManyRequests, errBusy
+	}
+}
+
+func releaseJobSlot() {
+	<-jobSlots
+	active.wg.Done()
+}
+
+// WaitForJobs stops new runs from starting and waits up to timeout for
+// the active ones, including background jobs, to finish. It reports
+// whether they all did.
+func WaitForJobs(timeout time.Duration) bool {
+	active.Lock()
+	active.draining = true
+	active.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		active.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// DefaultMaxUploadMB is the per-file upload limit used when none is set.
+const DefaultMaxUploadMB = 5
+
+// bodyHeadroom is what the request body may carry beyond the spreadsheet
+// (form fields, logo) so oversized files still reach the handler and get
+// a friendly error instead of being cut off by Fiber.
+const bodyHeadroom = 2 << 20
+
+var maxUploadMB = DefaultMaxUploadMB
+
+// SetMaxUploadMB sets the largest spreadsheet accepted, in megabytes. It
+// must be called before the server starts.
+func SetMaxUploadMB(n int) {
+	if n <= 0 {
+		n = DefaultMaxUploadMB
+	}
+	maxUploadMB = n
+}
+
+// BodyLimit is the Fiber body limit matching the upload limit.
+func BodyLimit() int {
+	return maxUploadMB<<20 + bodyHeadroom
+}
+
+// MaxUploadMB reports the configured upload limit for the page script.
+func MaxUploadMB() int {
+	return maxUploadMB
+}
+
+func errTooLarge() error {
+	return errMsg("too_large", maxUploadMB)
+}
+
+// folderLocks serializes runs writing into the same output folder, such
+// as supplements uploaded to a named folder, so they don't zip each
+// other's half-written files.
+var folderLocks sync.Map // output folder -> *sync.Mutex
+
+// lockFolder waits until no other run uses folder and returns the
+// function releasing it.
+func lockFolder(folder string) func() {
+	mu, _ := folderLocks.LoadOrStore(filepath.Clean(folder), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// jobTimeout bounds a single generation run; zero means no limit.
+var jobTimeout time.Duration
+
+// SetJobTimeout sets how long a generation run may take before it is
+// aborted. It must be called before the server starts.
+func SetJobTimeout(d time.Duration) {
+	jobTimeout = max(d, 0)
+}
+
+// jobContext returns the context a run is started with, carrying the
+// configured timeout.
+func jobContext() (context.Context, context.CancelFunc) {
+	if jobTimeout > 0 {
+		return context.WithTimeout(context.Background(), jobTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// errStatus is the HTTP status for a failed run: 504 when it hit the job
+// timeout, 507 when it outgrew MAX_OUTPUT_MB, 400 when the upload itself
+// was at fault and otherwise 500.
+func errStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fiber.StatusGatewayTimeout
+	}
+	var limitErr *service.OutputLimitError
+	if errors.As(err, &limitErr) {
+		return fiber.StatusInsufficientStorage
+	}
+	var inputErr *service.InputError
+	if errors.As(err, &inputErr) {
+		return fiber.StatusBadRequest
+	}
+	// with fail_fast an invalid row is the file's fault, a row that
+	// failed to render or save is not
+	var rowErr *service.RowError
+	if errors.As(err, &rowErr) && rowErr.Status == "invalid" {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"generate-code/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUploadJustOverLimit(t *testing.T) {
+	SetMaxUploadMB(1)
+	t.Cleanup(func() { SetMaxUploadMB(DefaultMaxUploadMB) })
+	t.Setenv("UPLOAD_FOLDER", t.TempDir())
+	t.Setenv("OUTPUT_BASE", t.TempDir())
+
+	app := fiber.New(fiber.Config{BodyLimit: BodyLimit()})
+	app.Post("/api/generate", APIGenerate)
+
+	post := func(size int) (int, map[string]string) {
+		t.Helper()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", "data.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := "NO IDENTITAS,NOMOR KK,NAMA LENGKAP,KODE QR\n"
+		part.Write([]byte(header + strings.Repeat("x", size-len(header))))
+		form.Close()
+
+		req := httptest.NewRequest("POST", "/api/generate", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("Accept-Language", "en")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]string
+		json.NewDecoder(resp.Body).Decode(&got)
+		return resp.StatusCode, got
+	}
+
+	status, got := post(1<<20 + 1)
+	if status != fiber.StatusRequestEntityTooLarge {
+		t.Fatalf("1MB + 1 byte: status %d, want %d", status, fiber.StatusRequestEntityTooLarge)
+	}
+	if want := "The file exceeds the 1MB limit."; got["error"] != want || got["code"] != "file_too_large" {
+		t.Errorf("1MB + 1 byte: got %q, want error %q with code file_too_large", got, want)
+	}
+
+	if status, got := post(1 << 20); status == fiber.StatusRequestEntityTooLarge {
+		t.Errorf("exactly 1MB: rejected as too large: %q", got)
+	}
+}
+
+func TestErrStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("stopped: %w", context.DeadlineExceeded), fiber.StatusGatewayTimeout},
+		{&service.OutputLimitError{LimitMB: 1, Result: &service.Result{}}, fiber.StatusInsufficientStorage},
+		{&service.InputError{Err: errors.New("missing column")}, fiber.StatusBadRequest},
+		{&service.RowError{Status: "invalid"}, fiber.StatusBadRequest},
+		{&service.RowError{Status: "error"}, fiber.StatusInternalServerError},
+		{errors.New("failed to zip: disk full"), fiber.StatusInternalServerError},
+	} {
+		if got := errStatus(tc.err); got != tc.want {
+			t.Errorf("errStatus(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
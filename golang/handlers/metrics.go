@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"generate-code/service"
+
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics serves the generation counters and timings in the Prometheus
+// text format, for scraping at /metrics.
+var Metrics = adaptor.HTTPHandler(promhttp.HandlerFor(service.MetricsRegistry, promhttp.HandlerOpts{}))
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"generate-code/service"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Preview renders a single QR from the query string and returns it as an
+// inline PNG, e.g. /preview?content=hello&ecc=high&fg_color=%23003366.
+// It takes the same style fields as the upload form.
+func Preview(c *fiber.Ctx) error {
+	opts, err := singleOptions(c)
+	if err != nil {
+		return jsonError(c, fiber.StatusBadRequest, err)
+	}
+
+	var buf bytes.Buffer
+	if err := service.PreviewQR(&buf, c.FormValue("content"), opts); err != nil {
+		return jsonError(c, fiber.StatusBadRequest, err)
+	}
+
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Send(buf.Bytes())
+}
+
+// APIQR renders a single QR from the form or query fields and responds
+// with it as a base64 data URI, for embedding without a download:
+//
+//	{"format": "png", "data_uri": "data:image/png;base64,..."}
+//
+// It takes the style fields of Preview plus format, jpeg_quality and
+// png_compression.
+func APIQR(c *fiber.Ctx) error {
+	opts, err := singleOptions(c)
+	if err != nil {
+		return jsonError(c, fiber.StatusBadRequest, err)
+	}
+	jpegQuality, err := intFormValue(c, "jpeg_quality")
+	if err != nil {
+		return jsonError(c, fiber.StatusBadRequest, err)
+	}
+	opts.Format = c.FormValue("format")
+	opts.JPEGQuality = jpegQuality
+	opts.PNGCompression = c.FormValue("png_compression")
+
+	uri, err := service.DataURI(c.FormValue("content"), opts)
+	if err != nil {
+		return jsonError(c, fiber.StatusBadRequest, err)
+	}
+	format := strings.ToLower(opts.Format)
+	if format == "" {
+		format = service.FormatPNG
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(fiber.Map{
+		"format":   format,
+		"data_uri": uri,
+	})
+}
+
+// singleOptions reads the style fields shared by the single-code
+// endpoints. FormValue also reads the query string.
+func singleOptions(c *fiber.Ctx) (service.Options, error) {
+	scale, err := intFormValue(c, "scale")
+	if err != nil {
+		return service.Options{}, err
+	}
+	supersample, err := intFormValue(c, "supersample")
+	if err != nil {
+		return service.Options{}, err
+	}
+	padding, err := intFormValue(c, "padding")
+	if err != nil {
+		return service.Options{}, err
+	}
+	var border *int
+	if strings.TrimSpace(c.FormValue("border")) != "" {
+		n, err := intFormValue(c, "border")
+		if err != nil {
+			return service.Options{}, err
+		}
+		border = &n
+	}
+	var gradientColor string
+	if c.FormValue("gradient_direction") != "" {
+		gradientColor = c.FormValue("gradient_color")
+	}
+	eyeColor, eyeBorderColor := eyeFormColors(c)
+	maxDimension, _ := strconv.Atoi(os.Getenv("MAX_IMAGE_DIMENSION"))
+	maxContentLength, _ := strconv.Atoi(os.Getenv("MAX_CONTENT_LENGTH"))
+	maxImageMB, _ := strconv.Atoi(os.Getenv("MAX_IMAGE_MB"))
+
+	return service.Options{
+		FgColor:           c.FormValue("fg_color"),
+		BgColor:           c.FormValue("bg_color"),
+		TransparentBg:     boolFormValue(c, "transparent_bg"),
+		StrictISO:         boolFormValue(c, "strict_iso"),
+		ECC:               c.FormValue("ecc"),
+		Scale:             scale,
+		Border:            border,
+		Padding:           padding,
+		MaxDimension:      maxDimension,
+		MaxContentLength:  maxContentLength,
+		MaxImageMB:        maxImageMB,
+		ContentMode:       c.FormValue("content_mode"),
+		ModuleStyle:       c.FormValue("module_style"),
+		Supersample:       supersample,
+		GradientColor:     gradientColor,
+		EyeColor:          eyeColor,
+		EyeBorderColor:    eyeBorderColor,
+		GradientDirection: c.FormValue("gradient_direction"),
+		Lang:              requestLang(c),
+	}, nil
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"generate-code/service"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errFound stops the folder walk once the image is found.
+var errFound = errors.New("found")
+
+// QRByNIK serves the image generated for one NIK, for reprinting a single
+// code without the whole zip. It looks in the output folder of the job
+// given by ?job=<id>, or of the most recent job whose folder still
+// exists, for a file named after the NIK by the job's filename template.
+func QRByNIK(c *fiber.Ctx) error {
+	nik := c.Params("nik")
+	// digits only, which also keeps the parameter out of the path
+	if nik == "" || service.CleanNumber(nik) != nik {
+		return jsonError(c, fiber.StatusBadRequest, errMsg("nik_not_digits"))
+	}
+
+	outputBase := os.Getenv("OUTPUT_BASE")
+	if outputBase == "" {
+		outputBase = "./qr_output"
+	}
+	rec, folder := findJobFolder(outputBase, c.Query("job"))
+	if rec == nil {
+		return jsonError(c, fiber.StatusNotFound, errMsg("job_folder_not_found"))
+	}
+	pattern, ok := service.FilenamePattern(rec.FilenameTemplate, nik)
+	if !ok {
+		return jsonError(c, fiber.StatusNotFound, errMsg("qr_not_found", nik))
+	}
+
+	var found string
+	filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if pattern.MatchString(d.Name()) {
+			found = path
+			return errFound
+		}
+		return nil
+	})
+	if found == "" {
+		return jsonError(c, fiber.StatusNotFound, errMsg("qr_not_found", nik))
+	}
+	return c.SendFile(found)
+}
+
+// findJobFolder returns the record of job id, or of the latest job when
+// id is empty, together with its output folder. Records whose folder was
+// cleaned up or swept are skipped.
+func findJobFolder(outputBase, id string) (*JobRecord, string) {
+	history.Lock()
+	defer history.Unlock()
+	for i := len(history.records) - 1; i >= 0; i-- {
+		rec := history.records[i]
+		if (id != "" && rec.ID != id) || rec.Folder == "" {
+			continue
+		}
+		folder, _, err := resolveOutputFile(outputBase, rec.Folder)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+			continue
+		}
+		return &rec, folder
+	}
+	return nil, ""
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sheetFetchTimeout bounds the whole download of a Google Sheet.
+const sheetFetchTimeout = 30 * time.Second
+
+var sheetClient = &http.Client{Timeout: sheetFetchTimeout}
+
+// sheetPath matches the document part of a Google Sheets URL: either a
+// regular sheet ID or a published-to-web "e/" ID.
+var sheetPath = regexp.MustCompile(`^/spreadsheets/d/(e/)?([A-Za-z0-9_-]+)`)
+
+// sheetCSVURL turns a Google Sheets link into its CSV export URL. Only
+// docs.google.com is accepted, so the server can't be pointed at other
+// hosts. Published links keep their /pub form; any other link is
+// rewritten to /export, keeping the selected tab (gid).
+func sheetCSVURL(raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host != "docs.google.com" {
+		return "", "", errMsg("invalid_sheets_url")
+	}
+	m := sheetPath.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", "", errMsg("invalid_sheets_url")
+	}
+	published, id := m[1] != "", m[2]
+
+	gid := u.Query().Get("gid")
+	if fragment, err := url.ParseQuery(u.Fragment); gid == "" && err == nil {
+		gid = fragment.Get("gid")
+	}
+
+	q := url.Values{}
+	export := &url.URL{Scheme: "https", Host: "docs.google.com"}
+	if published {
+		export.Path = "/spreadsheets/d/e/" + id + "/pub"
+		q.Set("output", "csv")
+	} else {
+		export.Path = "/spreadsheets/d/" + id + "/export"
+		q.Set("format", "csv")
+	}
+	if gid != "" {
+		q.Set("gid", gid)
+	}
+	export.RawQuery = q.Encode()
+	return export.String(), id, nil
+}
+
+// fetchSheet downloads the CSV export at exportURL into path, refusing
+// anything larger than the upload limit. Like prepareUpload it returns
+// the HTTP status describing a failure.
+func fetchSheet(exportURL, path string) (int, error) {
+	resp, err := sheetClient.Get(exportURL)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && urlErr.Timeout() {
+			return fiber.StatusGatewayTimeout, errMsg("sheets_timeout", sheetFetchTimeout)
+		}
+		return fiber.StatusBadGateway, errMsg("sheets_fetch_failed", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fiber.StatusBadGateway, errMsg("sheets_status", resp.StatusCode)
+	}
+	// private sheets answer with a sign-in page instead of an error
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/csv" {
+		return fiber.StatusBadRequest, errMsg("sheets_not_csv")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fiber.StatusInternalServerError, errMsg("save_failed", err)
+	}
+	limit := int64(maxUploadMB) << 20
+	n, err := io.Copy(f, io.LimitReader(resp.Body, limit+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err != nil:
+		os.Remove(path)
+		return fiber.StatusBadGateway, errMsg("sheets_fetch_failed", err)
+	case n > limit:
+		os.Remove(path)
+		return fiber.StatusRequestEntityTooLarge, errTooLarge()
+	}
+	return fiber.StatusOK, nil
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"generate-code/service"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// The job socket only pushes frames; clients send nothing but control
+// frames, so anything larger than this closes the socket.
+const wsReadLimit = 4096
+
+// wsWriteTimeout bounds every write, so a stalled client can't hold the
+// socket open.
+const wsWriteTimeout = 10 * time.Second
+
+// wsFrame is one message on /ws/jobs/:jobid: a "rows" frame per batch
+// of finished rows, then a single "done" frame with the Result or the
+// error.
+type wsFrame struct {
+	Type string              `json:"type"`
+	Rows []service.RowResult `json:"rows,omitempty"`
+	jobEvent
+}
+
+// jobSocket serves upgraded connections; the job is handed over in the
+// "job" local.
+var jobSocket = websocket.New(serveJobSocket)
+
+// JobSocket streams a job started via POST /api/jobs over a WebSocket:
+// the finished rows in "rows" frames, batched like Progress events (see
+// SetProgressBatch), then a "done" frame with the Result or error before
+// the socket closes. Rows finished before the client connected are sent
+// first. If the last client following the job goes away while it is
+// still running, the job is cancelled; others leaving don't stop it for
+// the rest.
+func JobSocket(c *fiber.Ctx) error {
+	job := getJob(c.Params("jobid"))
+	if job == nil {
+		return jsonError(c, fiber.StatusNotFound, errMsg("job_not_found"))
+	}
+	if !websocket.IsWebSocketUpgrade(c) {
+		return jsonError(c, fiber.StatusUpgradeRequired, errMsg("websocket_required"))
+	}
+	c.Locals("job", job)
+	return jobSocket(c)
+}
+
+func serveJobSocket(conn *websocket.Conn) {
+	job := conn.Locals("job").(*Job)
+	// why the client went away before the job finished, if it did
+	var left error
+	job.subscribe()
+	defer func() { job.unsubscribe(left) }()
+
+	// Reading is only needed to answer pings and notice a close or a
+	// dropped connection; the library rejects unmasked and oversized
+	// frames. The library reuses conn once we return, so the reader
+	// must be gone by then.
+	conn.SetReadLimit(wsReadLimit)
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	defer func() {
+		conn.Close()
+		<-gone
+	}()
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	ping := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+	}
+	writeJSON := func(v any) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(v)
+	}
+	sent := 0
+	for {
+		rows, ev, _ := job.rowsSince(sent)
+		if len(rows) > 0 {
+			frame := wsFrame{Type: "rows", Rows: rows, jobEvent: jobEvent{Progress: ev.Progress}}
+			if err := writeJSON(frame); err != nil {
+				left = err
+				return
+			}
+		}
+		sent += len(rows)
+		if ev.Done {
+			writeJSON(wsFrame{Type: "done", jobEvent: ev})
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteTimeout))
+			return
+		}
+		if err := job.waitBatch(ev.Progress.Processed, time.Now(), gone, keepAlive.C, ping); err != nil {
+			left = err
+			return
+		}
+	}
+}
+
+// subscribe counts a socket following the job.
+func (j *Job) subscribe() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.sockets++
+}
+
+// unsubscribe uncounts a socket, which left early when reason is set. The
+// job is cancelled when that leaves it running with no socket following.
+func (j *Job) unsubscribe(reason error) {
+	j.mu.Lock()
+	j.sockets--
+	last := j.sockets == 0 && !j.done
+	j.mu.Unlock()
+	if reason == nil {
+		return
+	}
+	if !last {
+		slog.Info("job socket closed, others still following", "job_id", j.ID, "reason", reason)
+		return
+	}
+	slog.Warn("job socket closed, cancelling job", "job_id", j.ID, "reason", reason)
+	j.cancel()
+}
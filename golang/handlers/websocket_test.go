@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"generate-code/service"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// startSocketServer serves JobSocket on a loopback port and returns its
+// address.
+func startSocketServer(t *testing.T) string {
+	t.Helper()
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws/jobs/:jobid", JobSocket)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	return ln.Addr().String()
+}
+
+// runningJob registers an unfinished job and returns it with a channel
+// that is closed once the job is cancelled.
+func runningJob(t *testing.T) (*Job, <-chan struct{}) {
+	t.Helper()
+	cancelled := make(chan struct{})
+	job := newJob(sync.OnceFunc(func() { close(cancelled) }))
+	t.Cleanup(func() {
+		jobs.Lock()
+		delete(jobs.byID, job.ID)
+		jobs.Unlock()
+	})
+	return job, cancelled
+}
+
+func waitCancelled(t *testing.T, cancelled <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not cancelled")
+	}
+}
+
+func dialJob(t *testing.T, addr string, job *Job) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws/jobs/"+job.ID, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestJobSocketStreamsRows(t *testing.T) {
+	addr := startSocketServer(t)
+	job, _ := runningJob(t)
+	job.addRow(service.RowResult{Row: 2, NIK: "3201234567890001", Status: "ok"})
+	job.addRow(service.RowResult{Row: 3, NIK: "3171011501900002", Status: "ok"})
+	job.setProgress(service.Progress{Processed: 2, Total: 2})
+	job.finish(&service.Result{}, nil)
+
+	conn := dialJob(t, addr, job)
+	var rows wsFrame
+	if err := conn.ReadJSON(&rows); err != nil {
+		t.Fatalf("reading rows frame: %v", err)
+	}
+	if rows.Type != "rows" || len(rows.Rows) != 2 || rows.Rows[1].Row != 3 {
+		t.Fatalf("rows frame = %+v", rows)
+	}
+	var done wsFrame
+	if err := conn.ReadJSON(&done); err != nil {
+		t.Fatalf("reading done frame: %v", err)
+	}
+	if done.Type != "done" || !done.Done || done.Result == nil {
+		t.Fatalf("done frame = %+v", done)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("after done: %v, want a normal close", err)
+	}
+}
+
+func TestJobSocketRequiresUpgrade(t *testing.T) {
+	app := fiber.New()
+	app.Get("/ws/jobs/:jobid", JobSocket)
+	job, _ := runningJob(t)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/ws/jobs/"+job.ID, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUpgradeRequired {
+		t.Errorf("plain GET: status %d, want %d", resp.StatusCode, fiber.StatusUpgradeRequired)
+	}
+	resp, err = app.Test(httptest.NewRequest("GET", "/ws/jobs/missing", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("unknown job: status %d, want %d", resp.StatusCode, fiber.StatusNotFound)
+	}
+}
+
+func TestJobSocketDisconnectCancels(t *testing.T) {
+	addr := startSocketServer(t)
+	job, cancelled := runningJob(t)
+
+	conn := dialJob(t, addr, job)
+	conn.Close()
+	waitCancelled(t, cancelled)
+}
+
+// A client leaving doesn't stop the job for another still following it;
+// the last one leaving does.
+func TestJobSocketLastSubscriberCancels(t *testing.T) {
+	addr := startSocketServer(t)
+	job, cancelled := runningJob(t)
+
+	first := dialJob(t, addr, job)
+	second := dialJob(t, addr, job)
+	waitSockets(t, job, 2)
+	first.Close()
+	waitSockets(t, job, 1)
+	select {
+	case <-cancelled:
+		t.Fatal("job cancelled while a client still follows it")
+	default:
+	}
+	second.Close()
+	waitCancelled(t, cancelled)
+}
+
+// waitSockets waits until n sockets follow job.
+func waitSockets(t *testing.T, job *Job, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		job.mu.Lock()
+		sockets := job.sockets
+		job.mu.Unlock()
+		if sockets == n {
+			return
+		}
+	}
+	t.Fatalf("job never had %d sockets", n)
+}
+
+func TestJobSocketRejectsOversizedFrame(t *testing.T) {
+	addr := startSocketServer(t)
+	job, cancelled := runningJob(t)
+
+	conn := dialJob(t, addr, job)
+	if err := conn.WriteMessage(websocket.TextMessage, make([]byte, 2*wsReadLimit)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("after oversized frame: %v, want close %d", err, websocket.CloseMessageTooBig)
+	}
+	waitCancelled(t, cancelled)
+}
+
+// Clients must mask their frames (RFC 6455 section 5.1); the server
+// closes the connection on an unmasked one.
+func TestJobSocketRejectsUnmaskedFrame(t *testing.T) {
+	addr := startSocketServer(t)
+	job, cancelled := runningJob(t)
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(nc, "GET /ws/jobs/"+job.ID+" HTTP/1.1\r\n"+
+		"Host: "+addr+"\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(nc)
+	status, err := br.ReadString('\n')
+	if err != nil || !strings.Contains(status, "101") {
+		t.Fatalf("handshake: %q, %v", status, err)
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	// FIN + text opcode, mask bit clear, two payload bytes.
+	if _, err := nc.Write([]byte{0x81, 0x02, 'h', 'i'}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		t.Fatalf("server kept the connection open: %v", err)
+	}
+	waitCancelled(t, cancelled)
+}
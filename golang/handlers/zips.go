@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ZipInfo describes an archive in OUTPUT_BASE.
+type ZipInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Download string    `json:"download"`
+}
+
+// ListZips returns the zips in OUTPUT_BASE, most recent first.
+func ListZips(c *fiber.Ctx) error {
+	outputBase := os.Getenv("OUTPUT_BASE")
+	if outputBase == "" {
+		outputBase = "./qr_output"
+	}
+
+	entries, err := os.ReadDir(outputBase)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return jsonError(c, fiber.StatusInternalServerError, err)
+	}
+	zips := []ZipInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".zip") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed while listing
+		}
+		zips = append(zips, ZipInfo{
+			Name:     entry.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+			Download: "/download/" + entry.Name(),
+		})
+	}
+	sort.Slice(zips, func(i, j int) bool {
+		return zips[i].Modified.After(zips[j].Modified)
+	})
+	return c.JSON(zips)
+}
+
+// DeleteZip removes one zip from OUTPUT_BASE. Like Download it only
+// accepts plain file names, and it refuses anything but zips.
+func DeleteZip(c *fiber.Ctx) error {
+	outputBase := os.Getenv("OUTPUT_BASE")
+	if outputBase == "" {
+		outputBase = "./qr_output"
+	}
+
+	path, status, err := resolveOutputFile(outputBase, c.Params("filename"))
+	if err != nil {
+		return jsonError(c, status, err)
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		return jsonError(c, fiber.StatusBadRequest, errMsg("not_a_zip"))
+	}
+	if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+		return jsonError(c, fiber.StatusNotFound, errMsg("zip_not_found"))
+	} else if err != nil {
+		return jsonError(c, fiber.StatusInternalServerError, err)
+	}
+	requestID, _ := c.Locals("requestid").(string)
+	slog.Info("zip deleted", "request_id", requestID, "path", path)
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -1,38 +1,160 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"generate-code/handlers"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/gofiber/template/html/v2"
 )
 
 func main() {
+	// Structured logs; LOG_LEVEL=debug|info|warn|error (default info)
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	// -cli runs a single generation without the web server
+	cli := flag.Bool("cli", false, "generate from the command line instead of serving HTTP")
+	var cf cliFlags
+	cf.register(flag.CommandLine)
+	flag.Parse()
+	if *cli {
+		os.Exit(runCLI(cf))
+	}
+
+	// Per-file upload limit in MB; the body limit leaves room above it
+	maxUploadMB, _ := strconv.Atoi(os.Getenv("MAX_UPLOAD_MB"))
+	handlers.SetMaxUploadMB(maxUploadMB)
+
 	// Initialize template engine
 	engine := html.New("./views", ".html")
+	engine.AddFunc("maxUploadMB", handlers.MaxUploadMB)
 
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
 		Views:     engine,
-		BodyLimit: 10 * 1024 * 1024, // 10MB to allow handler to catch >5MB files
+		BodyLimit: handlers.BodyLimit(), // above the file limit so the handler can report it
+		// JSON errors for API clients, the error page for browsers
+		ErrorHandler: handlers.ErrorHandler,
 	})
 
+	// Tag every request with an ID (X-Request-ID) used in the logs
+	app.Use(requestid.New())
+
+	// Optional API key; when API_KEY is unset every route stays open
+	if key := os.Getenv("API_KEY"); key != "" {
+		app.Use(handlers.RequireAPIKey(key))
+	}
+
+	// Limit simultaneous generation jobs
+	maxJobs, _ := strconv.Atoi(os.Getenv("MAX_CONCURRENT_JOBS"))
+	handlers.SetMaxConcurrentJobs(maxJobs)
+
+	// Abort runs taking longer than JOB_TIMEOUT, e.g. JOB_TIMEOUT=10m
+	if timeout, err := time.ParseDuration(os.Getenv("JOB_TIMEOUT")); err == nil {
+		handlers.SetJobTimeout(timeout)
+	}
+
+	// Coalesce progress stream events on large jobs, e.g.
+	// PROGRESS_BATCH_ROWS=500 PROGRESS_INTERVAL=500ms
+	batchRows, _ := strconv.Atoi(os.Getenv("PROGRESS_BATCH_ROWS"))
+	batchInterval, _ := time.ParseDuration(os.Getenv("PROGRESS_INTERVAL"))
+	handlers.SetProgressBatch(batchRows, batchInterval)
+
+	// Remember finished runs across restarts, e.g. JOBS_FILE=./jobs.json
+	if path := os.Getenv("JOBS_FILE"); path != "" {
+		if err := handlers.LoadJobHistory(path); err != nil {
+			log.Fatalf("failed to load job history: %v", err)
+		}
+	}
+
+	// Periodically remove stale zips, e.g. ZIP_TTL=24h
+	if ttl, err := time.ParseDuration(os.Getenv("ZIP_TTL")); err == nil && ttl > 0 {
+		handlers.StartZipSweeper(ttl)
+	}
+
 	// Static files
 	app.Static("/uploads", "./uploads")
 	app.Static("/qr_output", "./qr_output")
 
 	// Routes
+	app.Get("/healthz", handlers.Healthz(engine))
+	app.Get("/metrics", handlers.Metrics)
 	app.Get("/", handlers.Index)
 	app.Post("/", handlers.Upload)
 	app.Get("/download/:filename", handlers.Download)
+	app.Get("/qr/:nik", handlers.QRByNIK)
+	app.Get("/zips", handlers.ListZips)
+	app.Delete("/zips/:filename", handlers.DeleteZip)
+	app.Use("/files", handlers.Files())
+	app.Get("/preview", handlers.Preview)
+	app.Post("/api/generate", handlers.APIGenerate)
+	app.Post("/api/qr", handlers.APIQR)
+	app.Post("/api/jobs", handlers.StartJob)
+	app.Get("/progress/:jobid", handlers.Progress)
+	app.Get("/jobs", handlers.ListJobs)
+	app.Get("/jobs/:id", handlers.GetJob)
+	app.Get("/ws/jobs/:jobid", handlers.JobSocket)
 
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "5001"
 	}
-	log.Fatal(app.Listen(fmt.Sprintf(":%s", port)))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Serve HTTPS when both TLS_CERT and TLS_KEY name PEM files, for
+	// deployments without a TLS-terminating proxy
+	certFile, keyFile := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
+	if (certFile == "") != (keyFile == "") {
+		slog.Warn("TLS_CERT and TLS_KEY must be set together, serving plain HTTP")
+		certFile, keyFile = "", ""
+	}
+	go func() {
+		addr := fmt.Sprintf(":%s", port)
+		var err error
+		if certFile != "" {
+			slog.Info("serving HTTPS", "addr", addr, "cert", certFile)
+			err = app.ListenTLS(addr, certFile, keyFile)
+		} else {
+			slog.Info("serving HTTP", "addr", addr)
+			err = app.Listen(addr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGINT/SIGTERM stop accepting requests and let running
+	// generations finish, up to SHUTDOWN_TIMEOUT (default 5m)
+	<-ctx.Done()
+	stop()
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	slog.Info("shutting down", "timeout", timeout)
+	deadline := time.Now().Add(timeout)
+	if err := app.ShutdownWithTimeout(timeout); err != nil {
+		slog.Warn("server shutdown incomplete", "error", err)
+	}
+	slog.Info("server stopped, waiting for jobs")
+	if !handlers.WaitForJobs(time.Until(deadline)) {
+		slog.Warn("shutdown timed out with jobs still running")
+		os.Exit(1)
+	}
+	slog.Info("shutdown complete")
 }
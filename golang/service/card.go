@@ -0,0 +1,159 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Contact card formats for Options.Card.
+const (
+	CardMECARD = "mecard"
+	CardVCard  = "vcard"
+)
+
+// Contact card fields, the keys of Options.CardColumns.
+const (
+	CardName  = "name"
+	CardPhone = "phone"
+	CardEmail = "email"
+	CardOrg   = "org"
+)
+
+// cardFields is the order fields are written in.
+var cardFields = []string{CardName, CardPhone, CardEmail, CardOrg}
+
+// defaultCardColumns are read when CardColumns doesn't name a column.
+var defaultCardColumns = map[string]string{
+	CardName: ColName,
+}
+
+func (o Options) card() string {
+	return strings.ToLower(strings.TrimSpace(o.Card))
+}
+
+// cardColumn resolves the column a card field is read from, or "" when
+// the field is left out.
+func (o Options) cardColumn(field string) string {
+	column := strings.TrimSpace(o.CardColumns[field])
+	if column == "" {
+		column = defaultCardColumns[field]
+	}
+	if canonical, ok := canonicalColumns[column]; ok {
+		return canonical
+	}
+	return column
+}
+
+// cardColumns lists the columns the card is read from, which the file
+// must have.
+func (o Options) cardColumns() []string {
+	var columns []string
+	for _, field := range cardFields {
+		if column := o.cardColumn(field); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+func (o Options) cardPhoneFormat() string {
+	if o.CardPhoneFormat == "" {
+		return NumberPlus
+	}
+	return o.CardPhoneFormat
+}
+
+func (o Options) validateCard() error {
+	if err := o.validateNumberFormat("card_phone", o.CardPhoneFormat); err != nil {
+		return err
+	}
+	switch o.card() {
+	case "":
+		return nil
+	case CardMECARD, CardVCard:
+	default:
+		return errors.New(o.text("card_format_unsupported", o.Card, CardMECARD, CardVCard))
+	}
+	for field := range o.CardColumns {
+		known := false
+		for _, f := range cardFields {
+			known = known || f == field
+		}
+		if !known {
+			fields := append([]string(nil), cardFields...)
+			sort.Strings(fields)
+			return errors.New(o.text("card_field_unknown", field, strings.Join(fields, ", ")))
+		}
+	}
+	if len(o.ContentColumns) > 0 {
+		return errors.New(o.text("card_content_columns"))
+	}
+	return nil
+}
+
+// contactCard assembles the row's card fields into the configured
+// format. Empty fields are left out; it returns "" when all are empty.
+// Phone numbers are cleaned in CardPhoneFormat.
+func contactCard(row map[string]string, opts Options) string {
+	values := make(map[string]string, len(cardFields))
+	empty := true
+	for _, field := range cardFields {
+		value := ""
+		if column := opts.cardColumn(field); column != "" {
+			value = strings.TrimSpace(row[column])
+		}
+		if field == CardPhone {
+			value = FormatNumber(value, opts.cardPhoneFormat())
+		}
+		values[field] = value
+		if value != "" {
+			empty = false
+		}
+	}
+	if empty {
+		return ""
+	}
+	if opts.card() == CardVCard {
+		return vCard(values)
+	}
+	return meCard(values)
+}
+
+// meCard writes the DoCoMo MECARD format, e.g.
+// "MECARD:N:Budi;TEL:0812345;;". ORG isn't in the original format but is
+// read by common scanners.
+func meCard(values map[string]string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+	var b strings.Builder
+	b.WriteString("MECARD:")
+	for _, f := range []struct{ field, key string }{
+		{CardName, "N"}, {CardPhone, "TEL"}, {CardEmail, "EMAIL"}, {CardOrg, "ORG"},
+	} {
+		if v := values[f.field]; v != "" {
+			b.WriteString(f.key + ":" + escape.Replace(v) + ";")
+		}
+	}
+	b.WriteString(";")
+	return b.String()
+}
+
+// vCard writes a vCard 3.0 with CRLF line endings. The whole name goes
+// into FN and, as the family name, into the required N property.
+func vCard(values map[string]string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\n", `\n`)
+	lines := []string{"BEGIN:VCARD", "VERSION:3.0"}
+	name := escape.Replace(values[CardName])
+	lines = append(lines, "N:"+name+";;;;", "FN:"+name)
+	if v := values[CardOrg]; v != "" {
+		lines = append(lines, "ORG:"+escape.Replace(v))
+	}
+	if v := values[CardPhone]; v != "" {
+		lines = append(lines, "TEL;TYPE=CELL:"+v)
+	}
+	if v := values[CardEmail]; v != "" {
+		lines = append(lines, "EMAIL:"+escape.Replace(v))
+	}
+	lines = append(lines, "END:VCARD")
+	return strings.Join(lines, "\r\n")
+}
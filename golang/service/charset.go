@@ -0,0 +1,60 @@
+package service
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Supported CSV character sets. CharsetAuto reads UTF-8 unless the start
+// of the file isn't valid UTF-8, in which case it is taken as
+// Windows-1252, the usual encoding of CSV exported by Excel on Windows.
+const (
+	CharsetAuto        = "auto"
+	CharsetUTF8        = "utf-8"
+	CharsetWindows1252 = "windows-1252"
+	CharsetLatin1      = "iso-8859-1"
+)
+
+// charsetSample is how much of a CSV file is inspected to detect its
+// character set.
+const charsetSample = 64 << 10
+
+// charmaps decode the single-byte character sets to UTF-8.
+var charmaps = map[string]*charmap.Charmap{
+	CharsetWindows1252: charmap.Windows1252,
+	CharsetLatin1:      charmap.ISO8859_1,
+}
+
+// normalizeCharset maps common spellings onto the Charset constants.
+func normalizeCharset(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", CharsetAuto:
+		return CharsetAuto
+	case CharsetUTF8, "utf8":
+		return CharsetUTF8
+	case CharsetWindows1252, "cp1252":
+		return CharsetWindows1252
+	case CharsetLatin1, "latin1", "latin-1":
+		return CharsetLatin1
+	}
+	return name
+}
+
+// detectCharset picks UTF-8 when sample decodes as UTF-8 and
+// Windows-1252 otherwise. A rune cut off at the end of the sample doesn't
+// count against UTF-8.
+func detectCharset(sample []byte) string {
+	for len(sample) > 0 {
+		r, size := utf8.DecodeRune(sample)
+		if r == utf8.RuneError && size == 1 {
+			if utf8.FullRune(sample) {
+				return CharsetWindows1252
+			}
+			break
+		}
+		sample = sample[size:]
+	}
+	return CharsetUTF8
+}
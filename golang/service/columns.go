@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Internal column keys. A ColumnMap maps these keys to the header names
+// used in the uploaded spreadsheet.
+const (
+	ColNIK       = "nik"
+	ColKK        = "kk"
+	ColName      = "name"
+	ColQR        = "qr"
+	ColKecamatan = "kecamatan"
+	ColKelurahan = "kelurahan"
+	ColColor     = "color"
+)
+
+// canonicalColumns are the header names GenerateQR reads rows by. They are
+// also the default spreadsheet headers when no mapping is given.
+var canonicalColumns = map[string]string{
+	ColNIK:       "NO IDENTITAS",
+	ColKK:        "NOMOR KK",
+	ColName:      "NAMA LENGKAP",
+	ColQR:        "KODE QR",
+	ColKecamatan: "KECAMATAN",
+	ColKelurahan: "KELURAHAN",
+	ColColor:     "WARNA",
+}
+
+var requiredColumns = []string{ColNIK, ColKK, ColName, ColQR}
+
+// validateColumnMap rejects mappings for keys the generator does not know.
+func (o Options) validateColumnMap() error {
+	for key := range o.Columns {
+		if _, ok := canonicalColumns[key]; !ok {
+			known := make([]string, 0, len(canonicalColumns))
+			for k := range canonicalColumns {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return errors.New(o.text("column_key_unknown", key, strings.Join(known, ", ")))
+		}
+	}
+	return nil
+}
+
+// headerKeys checks that every required column is present and returns,
+// for each spreadsheet column, the key its cells should be stored under.
+// Mapped headers are translated to their canonical name; anything else is
+// kept as-is. When content columns are given they replace ColQR among the
+// required ones. A repeated header keeps its name on the first column
+// only; later ones become "NAME (2)" and so on, with a warning for each,
+// so their cells no longer overwrite the first.
+func headerKeys(headers []string, columns map[string]string, content []string) ([]string, []string, error) {
+	rename := make(map[string]string, len(canonicalColumns))
+	for key, canonical := range canonicalColumns {
+		source := canonical
+		if mapped := strings.TrimSpace(columns[key]); mapped != "" {
+			source = mapped
+		}
+		rename[source] = canonical
+	}
+
+	keys := make([]string, len(headers))
+	present := make(map[string]bool)
+	first := make(map[string]int)
+	var warnings []string
+	for i, h := range headers {
+		h = strings.TrimSpace(h)
+		if canonical, ok := rename[h]; ok {
+			h = canonical
+		}
+		if prev, ok := first[h]; ok && h != "" {
+			renamed := h
+			for n := 2; present[renamed]; n++ {
+				renamed = fmt.Sprintf("%s (%d)", h, n)
+			}
+			warnings = append(warnings, fmt.Sprintf("duplicate column %s in columns %d and %d; the second is read as %q", h, prev+1, i+1, renamed))
+			h = renamed
+			first[h] = i
+		} else {
+			first[h] = i
+		}
+		keys[i] = h
+		present[h] = true
+	}
+
+	// every missing column is reported at once, so a file can be fixed
+	// in one go
+	var missing, missingContent []string
+	for _, key := range requiredColumns {
+		if key == ColQR && len(content) > 0 {
+			continue
+		}
+		canonical := canonicalColumns[key]
+		if present[canonical] {
+			continue
+		}
+		if mapped := columns[key]; mapped != "" {
+			missing = append(missing, fmt.Sprintf("%s (mapped from %s)", mapped, key))
+		} else {
+			missing = append(missing, canonical)
+		}
+	}
+	for _, column := range content {
+		if !present[column] {
+			missingContent = append(missingContent, column)
+		}
+	}
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, missingList("required", missing))
+	}
+	if len(missingContent) > 0 {
+		problems = append(problems, missingList("content", missingContent))
+	}
+	if len(problems) > 0 {
+		return nil, nil, errors.New(strings.Join(problems, "; "))
+	}
+	return keys, warnings, nil
+}
+
+// missingList describes missing columns of the given kind, e.g.
+// "missing required columns: NOMOR KK, KODE QR".
+func missingList(kind string, names []string) string {
+	if len(names) == 1 {
+		return fmt.Sprintf("missing %s column: %s", kind, names[0])
+	}
+	return fmt.Sprintf("missing %s columns: %s", kind, strings.Join(names, ", "))
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultFilenameTemplate names files {nik}-{kk}-{name}, the original
+// layout. Fields are the column keys (ColNIK, ColKK, ...); the extension
+// is appended based on the output format.
+const DefaultFilenameTemplate = "{" + ColNIK + "}-{" + ColKK + "}-{" + ColName + "}"
+
+var templateField = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// validateTemplate checks a template of column key fields, such as the
+// filename template, rejecting unknown fields, stray braces or no field at
+// all, since the latter would give every row the same file name. kind is
+// the message key naming the template in the errors.
+func (o Options) validateTemplate(kind, template string) error {
+	kind = o.text(kind)
+	matches := templateField.FindAllStringSubmatch(template, -1)
+	if len(matches) == 0 {
+		return errors.New(o.text("template_no_field", kind, template))
+	}
+	for _, m := range matches {
+		if _, ok := canonicalColumns[m[1]]; !ok {
+			known := make([]string, 0, len(canonicalColumns))
+			for k := range canonicalColumns {
+				known = append(known, "{"+k+"}")
+			}
+			sort.Strings(known)
+			return errors.New(o.text("template_unknown_field", m[1], kind, strings.Join(known, ", ")))
+		}
+	}
+	if rest := templateField.ReplaceAllString(template, ""); strings.ContainsAny(rest, "{}") {
+		return errors.New(o.text("template_braces", kind, template))
+	}
+	return nil
+}
+
+// expandFilename fills template with the row's sanitized fields. It is
+// also used for Options.ContentFallback, with the raw fields.
+func expandFilename(template string, fields map[string]string) string {
+	return templateField.ReplaceAllStringFunc(template, func(m string) string {
+		return fields[m[1:len(m)-1]]
+	})
+}
+
+// FilenamePattern matches the names template gives the images of the row
+// with the given NIK, in any format and for any content column. Empty
+// template means DefaultFilenameTemplate. ok is false when the template
+// has no {nik} field, as its files can't be told apart by NIK.
+func FilenamePattern(template, nik string) (_ *regexp.Regexp, ok bool) {
+	if template == "" {
+		template = DefaultFilenameTemplate
+	}
+	if !strings.Contains(template, "{"+ColNIK+"}") {
+		return nil, false
+	}
+	// literal text is sanitized along with the fields when the name is
+	// built, and the name trimmed of leading underscores
+	literal := func(text string) string {
+		return regexp.QuoteMeta(filenameUnsafe.ReplaceAllString(text, "_"))
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, m := range templateField.FindAllStringSubmatchIndex(template, -1) {
+		text := literal(template[last:m[0]])
+		if last == 0 {
+			text = strings.TrimLeft(text, "_")
+		}
+		b.WriteString(text)
+		if template[m[2]:m[3]] == ColNIK {
+			b.WriteString(regexp.QuoteMeta(nik))
+		} else {
+			b.WriteString(".*")
+		}
+		last = m[1]
+	}
+	b.WriteString(literal(template[last:]))
+	b.WriteString(`(-.*)?\.[a-z]+$`)
+	return regexp.MustCompile(b.String()), true
+}
@@ -2,34 +2,136 @@ package service
 
 import (
 	"archive/zip"
-	"encoding/csv"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"image/jpeg"
 	"image/png"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/HugoSmits86/nativewebp"
 	"github.com/skip2/go-qrcode"
-	"github.com/xuri/excelize/v2"
+	xdraw "golang.org/x/image/draw"
 )
 
 type Result struct {
 	Generated   int      `json:"generated"`
 	Skipped     int      `json:"skipped"`
 	Invalid     int      `json:"invalid"`
+	Valid       int      `json:"valid"`      // rows passing all checks in validate-only mode
+	Duplicates  int      `json:"duplicates"` // rows skipped for repeating earlier QR content
 	Errors      []string `json:"errors"`
+	Warnings    []string `json:"warnings,omitempty"`
 	ZipFilename string   `json:"zip_filename"`
+	// ZipFilenames lists every archive produced: the single zip, or one
+	// per kecamatan in ZipPerKecamatan mode, each split into parts when
+	// it outgrows MaxZipBytes. ZipFilename is only set for a single zip.
+	ZipFilenames []string    `json:"zip_filenames"`
+	PDFFilename  string      `json:"pdf_filename,omitempty"`
+	Rows         []RowResult `json:"rows"`
+	// BytesWritten is the size of the images written by the run.
+	BytesWritten int64 `json:"bytes_written"`
+	// EmptyRowsSkipped counts rows without any value, which are left out
+	// rather than reported invalid.
+	EmptyRowsSkipped int `json:"empty_rows_skipped"`
+	// Tree lists the files in the output folder, grouped by folder, when
+	// Options.FileTree is set.
+	Tree []TreeFolder `json:"tree,omitempty"`
+	// DurationMs and RowsPerSec measure the rendering of all rows,
+	// excluding reading the file and zipping.
+	DurationMs int64   `json:"duration_ms"`
+	RowsPerSec float64 `json:"rows_per_sec"`
 }
 
+// TreeFolder is one folder of Result.Tree: its slash-separated path
+// relative to the output folder ("" for the output folder itself) and the
+// names of the files directly in it, sorted.
+type TreeFolder struct {
+	Folder string   `json:"folder"`
+	Files  []string `json:"files"`
+}
+
+// RowResult is the outcome for a single spreadsheet row. Row is the
+// 1-based line in the source file (the header is line 1), or in Sheet
+// when several sheets are read.
+type RowResult struct {
+	Row     int    `json:"row"`
+	Sheet   string `json:"sheet,omitempty"` // see Options.Sheets
+	NIK     string `json:"nik"`
+	Column  string `json:"column,omitempty"` // content column, see Options.ContentColumns
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// RowError is returned with Options.FailFast for the first row, in file
+// order, that was invalid or failed.
+type RowError struct {
+	Row     int
+	Sheet   string // see Options.Sheets
+	Column  string // content column, see Options.ContentColumns
+	Status  string // "invalid" or "error"
+	Message string // already prefixed with the row, as in Result.Errors
+	lang    string
+}
+
+func (e *RowError) Error() string {
+	return messages.Text(e.lang, "fail_fast", e.Message)
+}
+
+// InputError is returned when a run fails because of what it was given
+// rather than a fault of the server: invalid options, a file that can't
+// be read as a spreadsheet, a bad logo or too many rows.
+type InputError struct {
+	Err error
+}
+
+func (e *InputError) Error() string { return e.Err.Error() }
+
+func (e *InputError) Unwrap() error { return e.Err }
+
+// sourceRow is one data row keyed by column, with its line in the file.
+// Column names the content column when the row is one of several images
+// generated from the same line. Sheet is set when rows come from several
+// sheets.
+type sourceRow struct {
+	Line   int
+	Sheet  string
+	Values map[string]string
+	Column string
+}
+
+// Progress is a running snapshot of a generation run, reported through
+// Options.OnProgress after every processed row.
+type Progress struct {
+	Processed int `json:"processed"`
+	Total     int `json:"total"`
+	Generated int `json:"generated"`
+	Skipped   int `json:"skipped"`
+	Invalid   int `json:"invalid"`
+	Errors    int `json:"errors"`
+}
+
+// filenameUnsafe matches the characters SanitizeFilename replaces. Letters
+// and digits of any script are kept, so names like José or Müller stay
+// readable; combining marks are kept for names typed decomposed.
+var filenameUnsafe = regexp.MustCompile(`[^\p{L}\p{M}\p{N}._-]`)
+
 func SanitizeFilename(name string) string {
-	reg := regexp.MustCompile(`[^a-zA-Z0-9._-]`)
-	name = reg.ReplaceAllString(name, "_")
+	name = filenameUnsafe.ReplaceAllString(name, "_")
 	return strings.Trim(name, "_")
 }
 
@@ -39,298 +141,1035 @@ func SanitizeFolder(name string) string {
 	return strings.Trim(name, "_")
 }
 
+// CleanNumber keeps only the digits of value, the strict format NIK and
+// KK are checked in. See FormatNumber for the others.
 func CleanNumber(value string) string {
-	reg := regexp.MustCompile(`\D`)
-	return reg.ReplaceAllString(value, "")
-}
-
-func GenerateQR(row map[string]string, baseFolder string) (string, string) {
-    nikRaw := row["NO IDENTITAS"]
-    kkRaw := row["NOMOR KK"]
-    nik := CleanNumber(nikRaw)
-    noKK := CleanNumber(kkRaw)
-    nama := SanitizeFilename(strings.ReplaceAll(row["NAMA LENGKAP"], " ", "_"))
-    qrValue := strings.TrimSpace(row["KODE QR"])
-
-    if len(nik) != 16 {
-        return "invalid", fmt.Sprintf("Invalid NIK: %s", nik)
-    }
-    if len(noKK) != 16 {
-        return "invalid", fmt.Sprintf("Invalid KK: %s", noKK)
-    }
-
-    kec := SanitizeFolder(row["KECAMATAN"])
-    if kec == "" {
-        kec = "Kecamatan"
-    }
-    kel := SanitizeFolder(row["KELURAHAN"])
-    if kel == "" {
-        kel = "Kelurahan"
-    }
-
-    folder := filepath.Join(baseFolder, kec, kel)
-    if err := os.MkdirAll(folder, 0755); err != nil {
-        return "error", fmt.Sprintf("Failed to create dir: %v", err)
-    }
-
-    filename := SanitizeFilename(fmt.Sprintf("%s-%s-%s.png", nik, noKK, nama))
-    outPath := filepath.Join(folder, filename)
-
-    if _, err := os.Stat(outPath); err == nil {
-        return "skip", filename
-    }
-
-    if len(qrValue) > 500 {
-        return "invalid", "QR content too long"
-    }
-
-    // Create QR matrix
-    qr, err := qrcode.New(qrValue, qrcode.Highest)
-    if err != nil {
-        return "error", fmt.Sprintf("Failed to create QR: %v", err)
-    }
-    qr.DisableBorder = true // kita handle quiet zone secara manual
-
-    matrix := qr.Bitmap()
-    modules := len(matrix)
-
-    // === QR STYLE EXACT MATCH LIKE EXAMPLE ===
-    border := 4                // QR quiet zone per ISO
-    scale := 64                // pixel per module (high resolution)
-    finalSize := (modules + border*2) * scale
-
-    img := image.NewRGBA(image.Rect(0, 0, finalSize, finalSize))
-
-    // pure white background
-    draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
-
-    // draw QR blocks
-    for y := 0; y < modules; y++ {
-        for x := 0; x < modules; x++ {
-            if matrix[y][x] {
-                px := (x + border) * scale
-                py := (y + border) * scale
-                rect := image.Rect(px, py, px+scale, py+scale)
-                draw.Draw(img, rect, &image.Uniform{color.Black}, image.Point{}, draw.Src)
-            }
-        }
-    }
-
-    // Save PNG (lossless)
-    outFile, err := os.Create(outPath)
-    if err != nil {
-        return "error", fmt.Sprintf("Failed to save: %v", err)
-    }
-    defer outFile.Close()
-
-    encoder := png.Encoder{
-        CompressionLevel: png.BestCompression,
-    }
-    if err := encoder.Encode(outFile, img); err != nil {
-        return "error", fmt.Sprintf("PNG encode error: %v", err)
-    }
-
-    return "ok", filename
-}
-
-
-
-func RunGenerate(filePath string, outputFolder string) (*Result, error) {
-	var rows []map[string]string
-	var err error
-
-	ext := strings.ToLower(filepath.Ext(filePath))
-	if ext == ".xlsx" || ext == ".xls" {
-		rows, err = readExcel(filePath)
-	} else if ext == ".csv" {
-		rows, err = readCSV(filePath)
-	} else {
-		return nil, fmt.Errorf("unsupported file format: %s", ext)
+	return FormatNumber(value, NumberDigits)
+}
+
+// rowPlan is a validated row together with where its image belongs,
+// relative to the output root.
+type rowPlan struct {
+	Content  string
+	Dir      string
+	Filename string
+	Label    string // caption drawn under the code when Options.Label is set
+	Color    string // the row's WARNA cell, overriding the foreground
+}
+
+// planRow validates a row and derives its folder and file name. When the
+// row is rejected it returns nil with the status and message to report.
+func planRow(src sourceRow, opts Options) (*rowPlan, string, string) {
+	row := src.Values
+	nikRaw := row["NO IDENTITAS"]
+	kkRaw := row["NOMOR KK"]
+	nik := CleanNumber(nikRaw)
+	noKK := CleanNumber(kkRaw)
+	nama := SanitizeFilename(strings.ReplaceAll(row["NAMA LENGKAP"], " ", "_"))
+	qrValue := strings.TrimSpace(row["KODE QR"])
+	if src.Column != "" {
+		qrValue = strings.TrimSpace(row[src.Column])
+	}
+	if qrValue == "" && opts.ContentFallback != "" {
+		qrValue = strings.TrimSpace(expandFilename(opts.ContentFallback, map[string]string{
+			ColNIK:       nik,
+			ColKK:        noKK,
+			ColName:      strings.TrimSpace(row["NAMA LENGKAP"]),
+			ColKecamatan: strings.TrimSpace(row["KECAMATAN"]),
+			ColKelurahan: strings.TrimSpace(row["KELURAHAN"]),
+			ColColor:     strings.TrimSpace(row["WARNA"]),
+		}))
+	}
+	rawValue := qrValue // file names use the bare value, without prefix or suffix
+	if qrValue != "" {
+		qrValue = opts.ContentPrefix + qrValue + opts.ContentSuffix
+	}
+	if opts.card() != "" {
+		qrValue = contactCard(row, opts)
+		rawValue = strings.TrimSpace(row[opts.cardColumn(CardName)])
+	}
+	var wifiProblem string
+	if opts.WiFi {
+		qrValue, wifiProblem = wifiPayload(row, opts)
+		rawValue = strings.TrimSpace(row[WiFiSSIDColumn])
 	}
 
-	if err != nil {
-		return nil, err
+	if want := opts.nikLength(); len(nik) != want {
+		return nil, "invalid", opts.text("nik_length", nik, want, len(nik))
+	}
+	if opts.StrictNIK {
+		if msg := checkNIKStructure(nik, opts.Lang); msg != "" {
+			return nil, "invalid", msg
+		}
+	}
+	if want := opts.kkLength(); len(noKK) != want {
+		return nil, "invalid", opts.text("kk_length", noKK, want, len(noKK))
+	}
+	if qrValue == "" && opts.card() != "" {
+		return nil, "invalid", opts.text("card_empty")
+	}
+	if wifiProblem != "" {
+		return nil, "invalid", wifiProblem
+	}
+	if msg := checkContent(qrValue, opts); msg != "" {
+		return nil, "invalid", msg
 	}
 
-	if err := os.MkdirAll(outputFolder, 0755); err != nil {
-		return nil, err
+	kec := folderName(row, "KECAMATAN", opts.FolderPlaceholder)
+	kel := folderName(row, "KELURAHAN", opts.FolderPlaceholder)
+
+	var dirs []string
+	layout := opts.folderLayout()
+	if layout == LayoutVersion || layout == LayoutVersionColumns {
+		dir, err := versionFolder(qrValue, opts)
+		if err != nil {
+			return nil, "invalid", err.Error()
+		}
+		dirs = append(dirs, dir)
+	}
+	if layout != LayoutVersion {
+		for _, level := range opts.folderLevels() {
+			dirs = append(dirs, folderName(row, level, opts.FolderPlaceholder))
+		}
 	}
 
-	result := &Result{Errors: []string{}}
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	sem := make(chan struct{}, 6) // Max workers
+	name := expandFilename(opts.filenameTemplate(), map[string]string{
+		ColNIK:       nik,
+		ColKK:        noKK,
+		ColName:      nama,
+		ColQR:        SanitizeFilename(rawValue),
+		ColKecamatan: kec,
+		ColKelurahan: kel,
+		ColColor:     SanitizeFilename(row["WARNA"]),
+	})
+	if src.Column != "" {
+		name += "-" + SanitizeFilename(src.Column)
+	}
 
-	for _, row := range rows {
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(r map[string]string) {
-			defer wg.Done()
-			defer func() { <-sem }()
+	return &rowPlan{
+		Content:  qrValue,
+		Dir:      filepath.Join(dirs...),
+		Filename: SanitizeFilename(name + "." + opts.extension()),
+		Label:    strings.TrimSpace(rowValue(row, opts.Label)),
+		Color:    strings.TrimSpace(row["WARNA"]),
+	}, "", ""
+}
 
-			status, msg := GenerateQR(r, outputFolder)
-			mu.Lock()
-			switch status {
-			case "ok":
-				result.Generated++
-			case "skip":
-				result.Skipped++
-			case "invalid":
-				result.Invalid++
-			case "error":
-				result.Errors = append(result.Errors, msg)
-			}
-			mu.Unlock()
-		}(row)
+// checkContent explains why content can't be encoded with opts, or
+// returns "" when it can.
+func checkContent(content string, opts Options) string {
+	if limit := opts.maxContentLength(); len(content) > limit {
+		return opts.text("content_too_long", len(content), limit)
 	}
-	wg.Wait()
+	if level, err := opts.recoveryLevel(); err == nil {
+		if capacity := qrCapacity(content, level); len(content) > capacity {
+			return opts.text("content_over_capacity", len(content), capacity)
+		}
+	}
+	return checkContentMode(content, opts.contentMode(), opts.Lang)
+}
+
+// alphanumericChars is the character set of the QR alphanumeric mode.
+const alphanumericChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
 
-	// Zip the output
-	zipFilename := filepath.Base(outputFolder) + ".zip"
-	// Ensure zip is created in the parent directory of outputFolder
-	zipPath := filepath.Join(filepath.Dir(outputFolder), zipFilename)
-	
-	if err := zipFolder(outputFolder, zipPath); err != nil {
-		return nil, fmt.Errorf("failed to zip: %v", err)
+// checkContentMode explains in lang why content can't be encoded in
+// mode, or returns "" when it can.
+func checkContentMode(content, mode, lang string) string {
+	switch mode {
+	case ContentNumeric:
+		if content == "" || CleanNumber(content) != content {
+			return messages.Text(lang, "content_not_numeric", content)
+		}
+	case ContentAlphanumeric:
+		if content == "" || strings.Trim(content, alphanumericChars) != "" {
+			return messages.Text(lang, "content_not_alphanumeric", content)
+		}
 	}
-	result.ZipFilename = zipFilename
+	return ""
+}
 
-	return result, nil
+// qrCapacities is how many characters the largest QR version (40) holds
+// in numeric, alphanumeric and byte mode, for each recovery level from
+// Low to Highest.
+var qrCapacities = map[qrcode.RecoveryLevel][3]int{
+	qrcode.Low:     {7089, 4296, 2953},
+	qrcode.Medium:  {5596, 3391, 2331},
+	qrcode.High:    {3993, 2420, 1663},
+	qrcode.Highest: {3057, 1852, 1273},
 }
 
-func validateHeaders(headers []string) error {
-	required := []string{"NO IDENTITAS", "NOMOR KK", "NAMA LENGKAP", "KODE QR"}
-	headerMap := make(map[string]bool)
-	for _, h := range headers {
-		headerMap[strings.TrimSpace(h)] = true
+// qrCapacity is the most characters like content's that fit in one code
+// at level. Mixed content is measured in byte mode, which go-qrcode never
+// does worse than.
+func qrCapacity(content string, level qrcode.RecoveryLevel) int {
+	capacity := qrCapacities[level]
+	switch {
+	case checkContentMode(content, ContentNumeric, "") == "":
+		return capacity[0]
+	case checkContentMode(content, ContentAlphanumeric, "") == "":
+		return capacity[1]
 	}
+	return capacity[2]
+}
+
+// rowValue looks up column by header name or column key.
+func rowValue(row map[string]string, column string) string {
+	if canonical, ok := canonicalColumns[column]; ok {
+		return row[canonical]
+	}
+	return row[column]
+}
 
-	for _, req := range required {
-		if !headerMap[req] {
-			return fmt.Errorf("missing required column: %s", req)
+// folderName is the sanitized value of column or, when the cell is
+// empty, the sanitized placeholder; without one it is named after the
+// column (e.g. "Kecamatan"). Column keys such as ColKecamatan are
+// accepted as well as header names.
+func folderName(row map[string]string, column, placeholder string) string {
+	if canonical, ok := canonicalColumns[column]; ok {
+		column = canonical
+	}
+	if name := SanitizeFolder(row[column]); name != "" {
+		return name
+	}
+	if name := SanitizeFolder(placeholder); name != "" {
+		return name
+	}
+	placeholder = SanitizeFolder(strings.ToLower(column))
+	if placeholder == "" {
+		return "Folder"
+	}
+	return strings.ToUpper(placeholder[:1]) + placeholder[1:]
+}
+
+// versionFolder names the folder for content's QR version, e.g.
+// "V03-29x29", so codes of one module count end up together.
+func versionFolder(content string, opts Options) (string, error) {
+	level, err := opts.recoveryLevel()
+	if err != nil {
+		return "", err
+	}
+	version, err := qrVersion(content, level, opts.Lang)
+	if err != nil {
+		return "", err
+	}
+	modules := 17 + 4*version
+	return fmt.Sprintf("V%02d-%dx%d", version, modules, modules), nil
+}
+
+// GenerateQR writes the image for one row under baseFolder, returning the
+// row status and the file name or a message.
+func GenerateQR(row map[string]string, baseFolder string, opts Options) (string, string) {
+	return generateQR(sourceRow{Values: row}, baseFolder, opts)
+}
+
+func generateQR(row sourceRow, baseFolder string, opts Options) (string, string) {
+	plan, status, msg := planRow(row, opts)
+	if plan == nil {
+		return status, msg
+	}
+	if opts.ValidateOnly {
+		return "valid", ""
+	}
+
+	folder := filepath.Join(baseFolder, plan.Dir)
+	outPath := filepath.Join(folder, plan.Filename)
+	if _, err := os.Stat(outPath); err == nil && !opts.Overwrite {
+		return "skip", plan.Filename
+	}
+
+	// transient filesystem errors are retried with a doubling backoff;
+	// anything else fails at once
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := saveQR(baseFolder, folder, outPath, plan, opts)
+		if err == nil {
+			break
+		}
+		if attempt >= opts.WriteRetries || !transientWriteError(err) {
+			return "error", err.Error()
 		}
+		opts.logger().Warn("retrying write", "path", outPath, "attempt", attempt+1, "error", err)
+		time.Sleep(writeRetryBackoff << attempt)
+	}
+	observeRender(time.Since(start))
+	if opts.tally != nil {
+		opts.tally.add(outPath)
+	}
+
+	return "ok", plan.Filename
+}
+
+// writeRetryBackoff is the wait before the first write retry.
+const writeRetryBackoff = 100 * time.Millisecond
+
+// transientWriteError reports whether a failed write may succeed when
+// tried again: an interrupted or busy call, or a folder removed between
+// creating it and writing into it. Permission errors and a full disk
+// fail the row at once.
+func transientWriteError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.EINTR) || errors.Is(err, fs.ErrNotExist)
+}
+
+// saveQR renders plan into outPath, creating folder under baseFolder
+// first.
+func saveQR(baseFolder, folder, outPath string, plan *rowPlan, opts Options) error {
+	if err := opts.mkdirAll(baseFolder, folder); err != nil {
+		return fmt.Errorf("%s: %w", opts.text("create_dir_failed"), err)
+	}
+
+	outFile, err := opts.createFile(outPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", opts.text("save_failed"), err)
+	}
+
+	err = writeQR(outFile, plan, opts)
+	if closeErr := outFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("%s: %w", opts.text("save_failed"), closeErr)
+	}
+	if err != nil {
+		// don't leave a partial file behind for later runs to skip
+		os.Remove(outPath)
+		return err
 	}
 	return nil
 }
 
-func readExcel(filePath string) ([]map[string]string, error) {
-	f, err := excelize.OpenFile(filePath)
+// writeQR renders the planned row as a QR code in the configured format
+// and style.
+func writeQR(w io.Writer, plan *rowPlan, opts Options) error {
+	content := plan.Content
+	ro, err := opts.renderOptions()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if plan.Color != "" {
+		ro.Fg = rowColor(plan, ro, opts)
+	}
+	cv, err := newCanvas(content, ro)
+	if err != nil {
+		return err
+	}
+
+	height := cv.size()
+	if opts.Label != "" {
+		height += labelBand(cv.size())
+	}
+	// the QR content printed under the code, and any label
+	var shown string
+	if opts.ShowContent {
+		shown = content
+		band, err := contentBand(shown, cv.size())
+		if err != nil {
+			return err
+		}
+		height += band
+	}
+	if maxSize := opts.maxDimension(); height > maxSize {
+		return errors.New(opts.text("image_too_large", height, maxSize))
 	}
-	defer f.Close()
 
-	sheet := f.GetSheetName(0)
-	rows, err := f.GetRows(sheet)
+	var img *image.RGBA
+	if opts.format() != FormatSVG || opts.Verify {
+		if err := checkImageMemory(opts.Lang, cv.size(), height, opts.maxImageMB()); err != nil {
+			return err
+		}
+		if err := cv.checkSupersampleMemory(opts.maxImageMB()); err != nil {
+			return err
+		}
+		img = renderImage(cv)
+	}
+	if opts.Verify {
+		if err := verifyImage(img, content); err != nil {
+			return err
+		}
+	}
+
+	if opts.format() == FormatSVG {
+		if err := writeSVG(w, cv, plan.Label, opts.Label != "", shown); err != nil {
+			return fmt.Errorf("%s: %w", opts.text("encode_failed", "SVG"), err)
+		}
+		return nil
+	}
+
+	if opts.Label != "" {
+		if img, err = addLabel(img, plan.Label, ro.Fg, ro.Bg); err != nil {
+			return err
+		}
+	}
+	if shown != "" {
+		if img, err = addContent(img, shown, ro.Fg, ro.Bg); err != nil {
+			return err
+		}
+	}
+
+	switch opts.format() {
+	case FormatJPEG:
+		if err := jpeg.Encode(w, img, &jpeg.Options{Quality: opts.jpegQuality()}); err != nil {
+			return fmt.Errorf("%s: %w", opts.text("encode_failed", "JPEG"), err)
+		}
+		return nil
+	case FormatWebP:
+		// nativewebp only writes lossless (VP8L) images
+		if err := nativewebp.Encode(w, img, nil); err != nil {
+			return fmt.Errorf("%s: %w", opts.text("encode_failed", "WebP"), err)
+		}
+		return nil
+	}
+
+	// Save PNG (lossless)
+	encoder := png.Encoder{
+		CompressionLevel: opts.pngLevel(),
+	}
+	if !opts.PNGMetadata {
+		if err := encoder.Encode(w, img); err != nil {
+			return fmt.Errorf("%s: %w", opts.text("encode_failed", "PNG"), err)
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, img); err != nil {
+		return fmt.Errorf("%s: %w", opts.text("encode_failed", "PNG"), err)
+	}
+	return writePNGText(w, buf.Bytes(), pngMetadata(plan, opts, time.Now()))
+}
+
+// rowColor is the foreground for a row with a WARNA cell. A value that
+// isn't a hex color, or too close to the background, is logged and the
+// configured foreground kept, so the row still gets its code.
+func rowColor(plan *rowPlan, ro RenderOptions, opts Options) color.RGBA {
+	if opts.StrictISO {
+		opts.logger().Warn("ignoring row color in strict ISO mode", "file", plan.Filename, "color", plan.Color)
+		return ro.Fg
+	}
+	fg, err := ParseHexColor(plan.Color)
 	if err != nil {
-		return nil, err
+		opts.logger().Warn("ignoring row color", "file", plan.Filename, "color", plan.Color, "error", err)
+		return ro.Fg
+	}
+	if ro.Bg.A != 0 {
+		if ratio := ContrastRatio(fg, ro.Bg); ratio < MinContrastRatio {
+			opts.logger().Warn("ignoring row color", "file", plan.Filename, "color", plan.Color,
+				"error", fmt.Sprintf("contrast ratio with %s is %.2f, minimum is %.1f", hexColor(ro.Bg), ratio, MinContrastRatio))
+			return ro.Fg
+		}
 	}
+	return fg
+}
+
+// canvas is a code matrix together with how to draw it, shared by the
+// raster and SVG renderers.
+type canvas struct {
+	matrix [][]bool
+	border int // quiet zone, in modules
+	scale  int // pixels per module
+	fg, bg color.RGBA
+	logo   image.Image
+	style  string
+	// padding is margin around the quiet zone, in pixels
+	padding int
+	// samples is the supersampling factor, 1 for none
+	samples int
+	// gradient, when set, blends the modules from fg to gradientEnd;
+	// finder patterns keep the plain fg color
+	gradient    string
+	gradientEnd color.RGBA
+	// eye and eyeFrame, unless zero, color the finder patterns and their
+	// outer frame
+	eye, eyeFrame color.RGBA
+	lang          string // of the errors, see RenderOptions.Lang
+}
+
+// uniform reports whether every dark module has the fg color.
+func (c canvas) uniform() bool {
+	return c.gradient == "" && c.eye.A == 0 && c.eyeFrame.A == 0
+}
 
-	if len(rows) < 2 {
-		return nil, fmt.Errorf("empty excel file")
+// moduleColor is the fill of the dark module at (x, y).
+func (c canvas) moduleColor(x, y int) color.RGBA {
+	modules := len(c.matrix)
+	if inFinder(x, y, modules) {
+		if c.eyeFrame.A != 0 && inFinderFrame(x, y, modules) {
+			return c.eyeFrame
+		}
+		if c.eye.A != 0 {
+			return c.eye
+		}
+		return c.fg
+	}
+	if c.gradient == "" {
+		return c.fg
 	}
+	return lerpColor(c.fg, c.gradientEnd, gradientPosition(c.gradient, x, y, modules))
+}
 
-	headers := rows[0]
-	if err := validateHeaders(headers); err != nil {
-		return nil, err
+// checkSupersampleMemory applies checkImageMemory to the enlarged image
+// supersampling draws first.
+func (c canvas) checkSupersampleMemory(maxMB int) error {
+	if c.samples <= 1 {
+		return nil
 	}
+	return checkImageMemory(c.lang, c.size()*c.samples, c.size()*c.samples, maxMB)
+}
+
+// size is the width and height of the code including its quiet zone
+// and padding.
+func (c canvas) size() int {
+	return (len(c.matrix)+c.border*2)*c.scale + c.padding*2
+}
 
-	var result []map[string]string
-	for _, row := range rows[1:] {
-		data := make(map[string]string)
-		for i, cell := range row {
-			if i < len(headers) {
-				data[headers[i]] = cell
+// origin is the pixel offset of the module at (x, y).
+func (c canvas) origin(x, y int) (int, int) {
+	return c.padding + (x+c.border)*c.scale, c.padding + (y+c.border)*c.scale
+}
+
+// renderImage draws the matrix as a raster image with a border-module
+// quiet zone and padding, overlaying the logo if there is one. With
+// supersampling the code is drawn samples times larger and scaled down.
+func renderImage(c canvas) *image.RGBA {
+	if c.samples > 1 {
+		large := c
+		large.scale *= c.samples
+		large.padding *= c.samples
+		large.samples = 1
+		large.logo = nil
+		src := renderImage(large)
+		img := image.NewRGBA(image.Rect(0, 0, c.size(), c.size()))
+		xdraw.CatmullRom.Scale(img, img.Bounds(), src, src.Bounds(), draw.Src, nil)
+		if c.logo != nil {
+			drawLogo(img, c.logo, c.size())
+		}
+		return img
+	}
+
+	modules := len(c.matrix)
+	finalSize := c.size()
+	img := image.NewRGBA(image.Rect(0, 0, finalSize, finalSize))
+
+	// solid background
+	draw.Draw(img, img.Bounds(), &image.Uniform{c.bg}, image.Point{}, draw.Src)
+
+	// draw QR blocks
+	fg := &image.Uniform{c.fg}
+	mask := moduleMask(c.style, c.scale)
+	for y := 0; y < modules; y++ {
+		for x := 0; x < modules; x++ {
+			if c.matrix[y][x] {
+				if !c.uniform() {
+					fg = &image.Uniform{c.moduleColor(x, y)}
+				}
+				px, py := c.origin(x, y)
+				rect := image.Rect(px, py, px+c.scale, py+c.scale)
+				if mask == nil || inFinder(x, y, modules) {
+					draw.Draw(img, rect, fg, image.Point{}, draw.Src)
+				} else {
+					draw.DrawMask(img, rect, fg, image.Point{}, mask, image.Point{}, draw.Over)
+				}
 			}
 		}
-		result = append(result, data)
 	}
-	return result, nil
+
+	// logo overlay on top of the center modules
+	if c.logo != nil {
+		drawLogo(img, c.logo, finalSize)
+	}
+	return img
+}
+
+// writeSVG emits the matrix as a vector image using the same border and
+// scale math as the PNG renderer, so both formats line up pixel-for-pixel.
+func writeSVG(w io.Writer, c canvas, label string, withLabel bool, content string) error {
+	modules := len(c.matrix)
+	finalSize := c.size()
+	height := finalSize
+	if withLabel {
+		height += labelBand(finalSize)
+	}
+	contentTop := height
+	if content != "" {
+		band, err := contentBand(content, finalSize)
+		if err != nil {
+			return err
+		}
+		height += band
+	}
+	// curved modules look jagged without anti-aliasing
+	rendering := "crispEdges"
+	if c.style != StyleSquare {
+		rendering = "geometricPrecision"
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="%s">`+"\n", finalSize, height, finalSize, height, rendering)
+	if c.bg.A != 0 {
+		fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="%s"/>`+"\n", finalSize, height, hexColor(c.bg))
+	}
+	for y := 0; y < modules; y++ {
+		for x := 0; x < modules; x++ {
+			if c.matrix[y][x] {
+				px, py := c.origin(x, y)
+				style := c.style
+				if inFinder(x, y, modules) {
+					style = StyleSquare
+				}
+				writeSVGModule(bw, style, px, py, c.scale, hexColor(c.moduleColor(x, y)))
+			}
+		}
+	}
+	if c.logo != nil {
+		if err := writeSVGLogo(bw, c.logo, finalSize); err != nil {
+			return err
+		}
+	}
+	if withLabel {
+		if err := writeSVGLabel(bw, label, finalSize, c.fg); err != nil {
+			return err
+		}
+	}
+	if content != "" {
+		if err := writeSVGContent(bw, content, finalSize, contentTop, c.fg); err != nil {
+			return err
+		}
+	}
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+// RunGenerate is Generate with the input file and output folder passed
+// separately from the other options.
+func RunGenerate(ctx context.Context, filePath string, outputFolder string, opts Options) (*Result, error) {
+	opts.FilePath = filePath
+	opts.OutputFolder = outputFolder
+	start := time.Now()
+	result, err := Generate(ctx, opts)
+	observeJob(time.Since(start), err)
+	return result, err
 }
 
-func readCSV(filePath string) ([]map[string]string, error) {
-	f, err := os.Open(filePath)
+// Generate reads opts.FilePath and writes one image per valid row under
+// opts.OutputFolder, then archives the folder according to opts.ZipMode.
+// Zips and the optional PDF are placed next to the output folder and
+// reported by name in the Result.
+//
+// Cancelling ctx, or reaching its deadline, stops the run between rows
+// and while zipping; the error then wraps ctx.Err(). Going over
+// opts.MaxOutputMB stops it the same way with an *OutputLimitError.
+func Generate(ctx context.Context, opts Options) (*Result, error) {
+	filePath, outputFolder := opts.FilePath, opts.OutputFolder
+	if filePath == "" {
+		return nil, fmt.Errorf("no input file given")
+	}
+	if outputFolder == "" && !opts.ValidateOnly {
+		return nil, fmt.Errorf("no output folder given")
+	}
+	src, total, err := openSource(filePath, &opts)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	defer src.Close()
+
+	if !opts.ValidateOnly {
+		if err := opts.mkdirAll(outputFolder, outputFolder); err != nil {
+			return nil, err
+		}
+	}
 
-	r := csv.NewReader(f)
-	headers, err := r.Read()
+	opts.tally = &outputTally{}
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	limit := int64(opts.MaxOutputMB) << 20
+	result, err := processRows(ctx, src, total, opts, func(row sourceRow) (string, string) {
+		status, msg := generateQR(row, outputFolder, opts)
+		if limit > 0 && status == "ok" && opts.tally.total() > limit {
+			stop(errOutputLimit)
+		}
+		return status, msg
+	})
+	if result != nil {
+		result.BytesWritten = opts.tally.total()
+	}
+	if errors.Is(err, errOutputLimit) {
+		opts.tally.remove(outputFolder, opts)
+		opts.logger().Warn("output size limit exceeded, partial output removed",
+			"limit_mb", opts.MaxOutputMB, "bytes_written", result.BytesWritten)
+		return nil, &OutputLimitError{LimitMB: opts.MaxOutputMB, Result: result, lang: opts.Lang}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if err := validateHeaders(headers); err != nil {
-		return nil, err
+	if opts.ValidateOnly {
+		return result, nil
 	}
 
-	var result []map[string]string
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
+	if opts.Manifest {
+		if err := saveManifest(outputFolder, filePath, result, opts); err != nil {
+			return nil, fmt.Errorf("failed to write manifest: %v", err)
 		}
+	}
+	if err := saveErrorReport(outputFolder, result, opts); err != nil {
+		return nil, fmt.Errorf("failed to write error report: %v", err)
+	}
+	if opts.FileTree {
+		if result.Tree, err = fileTree(outputFolder); err != nil {
+			return nil, fmt.Errorf("failed to list output: %v", err)
+		}
+	}
+
+	if opts.PDF {
+		pdfFilename := filepath.Base(outputFolder) + ".pdf"
+		pdfPath := filepath.Join(filepath.Dir(outputFolder), pdfFilename)
+		if err := savePDF(pdfPath, outputFolder, filePath, result, opts); err != nil {
+			return nil, err
+		}
+		result.PDFFilename = pdfFilename
+	}
+
+	switch opts.zipMode() {
+	case ZipNone:
+		result.ZipFilenames = []string{}
+	case ZipPerKecamatan:
+		names, err := zipPerKecamatan(ctx, outputFolder, opts.zipBase(outputFolder), opts)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("failed to zip: %v", err)
 		}
-		data := make(map[string]string)
-		for i, cell := range record {
-			if i < len(headers) {
-				data[headers[i]] = cell
-			}
+		result.ZipFilenames = names
+	default:
+		// Zip the output
+		zipFilename := opts.zipBase(outputFolder) + ".zip"
+		// Ensure zip is created in the parent directory of outputFolder
+		zipPath := filepath.Join(filepath.Dir(outputFolder), zipFilename)
+
+		names, err := zipFolder(ctx, outputFolder, zipPath, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to zip: %v", err)
+		}
+		if len(names) == 1 {
+			result.ZipFilename = zipFilename
+		}
+		result.ZipFilenames = names
+	}
+
+	if opts.Cleanup {
+		if err := os.RemoveAll(outputFolder); err != nil {
+			opts.logger().Warn("cleanup failed", "path", outputFolder, "error", err)
+		} else {
+			opts.logger().Info("cleanup removed output folder", "path", outputFolder)
 		}
-		result = append(result, data)
 	}
+
 	return result, nil
 }
 
-func zipFolder(source, target string) error {
-	zipfile, err := os.Create(target)
+// openSource validates opts, loads shared resources such as the logo into
+// it, and opens the spreadsheet for reading. The rows are counted up front
+// to enforce the row limit and so Progress.Total is known. Every error is
+// an *InputError.
+func openSource(filePath string, opts *Options) (rowReader, int, error) {
+	src, total, err := openInput(filePath, opts)
 	if err != nil {
-		return err
+		return nil, 0, &InputError{Err: err}
 	}
-	defer zipfile.Close()
+	return src, total, nil
+}
 
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
+func openInput(filePath string, opts *Options) (rowReader, int, error) {
+	if err := opts.validate(); err != nil {
+		return nil, 0, err
+	}
+	if opts.LogoPath != "" {
+		logo, err := loadLogo(opts.LogoPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		opts.logo = logo
+	}
 
-	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+	total := 0
+	if limit := opts.maxRows(); limit > 0 || opts.OnProgress != nil {
+		n, err := countRows(filePath, *opts)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
+		if limit > 0 && n > limit {
+			return nil, 0, errors.New(opts.text("row_limit", n, limit))
+		}
+		total = n * max(len(opts.ContentColumns), 1)
+	}
 
-		header, err := zip.FileInfoHeader(info)
+	src, err := openRows(filePath, *opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return src, total, nil
+}
+
+// processRows reads src row by row and runs handle for each on the
+// worker pool, tallying the returned statuses into a Result. Rows are
+// dispatched as they are read, so at most one row per worker is held in
+// memory besides the per-row results. Once ctx is done no further rows
+// are started; rows already rendering are finished, and returned with the
+// error.
+func processRows(ctx context.Context, src rowReader, total int, opts Options, handle func(sourceRow) (string, string)) (*Result, error) {
+	result := &Result{
+		Errors:   []string{},
+		Warnings: append(opts.warnings(), src.Warnings()...),
+		Rows:     []RowResult{},
+	}
+	progress := Progress{Total: total}
+	// first line each QR content was seen on, tracked while reading so
+	// "earlier" always means file order
+	var firstLine map[string]int
+	if opts.DedupContent {
+		firstLine = make(map[string]int)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, opts.workers())
+	// with FailFast the first failure cancels the rows not yet started
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	var failed *RowError
+	failedAt := 0 // index of failed, as lines restart in every sheet
+	logger := opts.logger()
+	logger.Info("generation started", "workers", opts.workers())
+	start := time.Now()
+
+	var readErr error
+	done := 0
+	for i := 0; ctx.Err() == nil; i++ {
+		row, err := src.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return err
+			readErr = err
+			break
 		}
 
-		header.Name, err = filepath.Rel(filepath.Dir(source), path)
+		first, duplicate := 0, false
+		if firstLine != nil {
+			if plan, _, _ := planRow(row, opts); plan != nil {
+				first, duplicate = firstLine[plan.Content]
+				if !duplicate {
+					firstLine[plan.Content] = row.Line
+				}
+			}
+		}
+
+		mu.Lock()
+		result.Rows = append(result.Rows, RowResult{})
+		mu.Unlock()
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, r sourceRow) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			var status, msg string
+			if duplicate {
+				status, msg = "skip", opts.text("duplicate_content", first)
+			} else {
+				status, msg = handle(r)
+			}
+			if status == "invalid" || status == "error" {
+				// say where the row is, so it can be found in large files
+				where := opts.text("row", r.Line)
+				if r.Sheet != "" {
+					where = opts.text("sheet_row", r.Sheet, r.Line)
+				}
+				if r.Column != "" {
+					msg = fmt.Sprintf("%s (%s): %s", where, r.Column, msg)
+				} else {
+					msg = fmt.Sprintf("%s: %s", where, msg)
+				}
+			}
+			observeRow(status)
+			mu.Lock()
+			done++
+			if opts.FailFast && (status == "invalid" || status == "error") {
+				if failed == nil || i < failedAt {
+					failed = &RowError{Row: r.Line, Sheet: r.Sheet, Column: r.Column, Status: status, Message: msg, lang: opts.Lang}
+					failedAt = i
+				}
+				stop()
+			}
+			if duplicate {
+				result.Duplicates++
+			}
+			result.Rows[i] = RowResult{
+				Row:     r.Line,
+				Sheet:   r.Sheet,
+				NIK:     CleanNumber(r.Values["NO IDENTITAS"]),
+				Column:  r.Column,
+				Status:  status,
+				Message: msg,
+			}
+			switch status {
+			case "ok":
+				result.Generated++
+			case "skip":
+				result.Skipped++
+			case "invalid":
+				result.Invalid++
+			case "valid":
+				result.Valid++
+			case "error":
+				result.Errors = append(result.Errors, msg)
+				logger.Error("row failed", "row", r.Line, "nik", result.Rows[i].NIK, "error", msg)
+			}
+			if opts.OnProgress != nil {
+				progress.Processed++
+				progress.Generated = result.Generated
+				progress.Skipped = result.Skipped
+				progress.Invalid = result.Invalid
+				progress.Errors = len(result.Errors)
+				opts.OnProgress(progress)
+			}
+			if opts.OnRow != nil {
+				opts.OnRow(result.Rows[i])
+			}
+			mu.Unlock()
+		}(i, row)
+	}
+	wg.Wait()
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read rows: %v", readErr)
+	}
+	if failed != nil {
+		logger.Warn("generation stopped at failed row", "row", failed.Row, "error", failed.Message)
+		return nil, failed
+	}
+	if ctx.Err() != nil {
+		err := context.Cause(ctx)
+		logger.Warn("generation stopped", "rows_done", done, "error", err)
+		// the rows that finished, for callers reporting a partial run
+		finished := result.Rows[:0]
+		for _, row := range result.Rows {
+			if row.Status != "" {
+				finished = append(finished, row)
+			}
+		}
+		result.Rows = finished
+		return result, fmt.Errorf("generation stopped after %d rows: %w", done, err)
+	}
+
+	result.EmptyRowsSkipped = emptyRowsSkipped(src)
+	elapsed := time.Since(start)
+	result.DurationMs = elapsed.Milliseconds()
+	if secs := elapsed.Seconds(); secs > 0 {
+		result.RowsPerSec = float64(len(result.Rows)) / secs
+	}
+	logger.Info("generation finished",
+		"rows", len(result.Rows),
+		"generated", result.Generated,
+		"skipped", result.Skipped,
+		"invalid", result.Invalid,
+		"valid", result.Valid,
+		"duplicates", result.Duplicates,
+		"empty_rows", result.EmptyRowsSkipped,
+		"errors", len(result.Errors),
+		"duration_ms", result.DurationMs,
+	)
+
+	return result, nil
+}
+
+// fileTree walks outputFolder for Result.Tree. Folders without files of
+// their own are left out. The walk visits entries in lexical order, so
+// files come out sorted; folders are sorted by path.
+func fileTree(outputFolder string) ([]TreeFolder, error) {
+	tree := []TreeFolder{}
+	index := make(map[string]int)
+	err := filepath.WalkDir(outputFolder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(outputFolder, filepath.Dir(path))
 		if err != nil {
 			return err
 		}
+		folder := filepath.ToSlash(rel)
+		if folder == "." {
+			folder = ""
+		}
+		i, ok := index[folder]
+		if !ok {
+			i = len(tree)
+			index[folder] = i
+			tree = append(tree, TreeFolder{Folder: folder})
+		}
+		tree[i].Files = append(tree[i].Files, d.Name())
+		return nil
+	})
+	sort.Slice(tree, func(i, j int) bool { return tree[i].Folder < tree[j].Folder })
+	return tree, err
+}
 
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
+// zipPerKecamatan writes one archive per top-level folder of outputFolder,
+// named "<base>-<kecamatan>.zip" next to outputFolder, or
+// "<base>-<kecamatan>.part1.zip" and so on when split by MaxZipBytes.
+func zipPerKecamatan(ctx context.Context, outputFolder, base string, opts Options) ([]string, error) {
+	entries, err := os.ReadDir(outputFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		zipFilename := base + "-" + entry.Name() + ".zip"
+		zipPath := filepath.Join(filepath.Dir(outputFolder), zipFilename)
+		parts, err := zipFolder(ctx, filepath.Join(outputFolder, entry.Name()), zipPath, opts)
+		if err != nil {
+			return nil, err
 		}
+		names = append(names, parts...)
+	}
+	return names, nil
+}
 
-		writer, err := archive.CreateHeader(header)
+// zipFolder archives source into target, split into parts when
+// opts.MaxZipBytes is set, and returns the names of the archives written.
+func zipFolder(ctx context.Context, source, target string, opts Options) ([]string, error) {
+	parts := newZipParts(target, opts)
+	if err := parts.removeStale(); err != nil {
+		return nil, err
+	}
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("zipping stopped: %w", err)
+		}
 
-		if info.IsDir() {
-			return nil
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
 		}
 
-		file, err := os.Open(path)
+		header.Name, err = filepath.Rel(filepath.Dir(source), path)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-		_, err = io.Copy(writer, file)
-		return err
+
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = opts.zipMethod()
+		}
+		return parts.add(header, path)
 	})
+	if ferr := parts.finish(); err == nil {
+		err = ferr
+	}
+	return parts.names, err
 }
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestTransientWriteError(t *testing.T) {
+	pathErr := func(errno error) error {
+		return fmt.Errorf("Failed to save: %w", &fs.PathError{Op: "open", Path: "x.png", Err: errno})
+	}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{pathErr(syscall.EAGAIN), true},
+		{pathErr(syscall.EBUSY), true},
+		{pathErr(syscall.EINTR), true},
+		{pathErr(syscall.ENOENT), true},
+		{pathErr(syscall.EACCES), false},
+		{pathErr(syscall.EPERM), false},
+		{pathErr(syscall.ENOSPC), false},
+		{errors.New("PNG encode error"), false},
+	}
+	for _, tt := range tests {
+		if got := transientWriteError(tt.err); got != tt.want {
+			t.Errorf("transientWriteError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// A long content string at a large scale would need hundreds of MB per
+// image; it must be refused before anything is allocated. The content
+// limit is raised so the guards are reached.
+func TestOversizedImageRejected(t *testing.T) {
+	content := strings.Repeat("3201234567890001 SITI AMINAH ", 50)
+	row := map[string]string{
+		"NO IDENTITAS": "3201234567890001",
+		"NOMOR KK":     "3201230101010002",
+		"NAMA LENGKAP": "Siti Aminah",
+		"KODE QR":      content,
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"dimension", Options{Scale: 128, MaxContentLength: 2000}, "exceeds limit of 16384px"},
+		{"memory", Options{Scale: 64, MaxContentLength: 2000}, "exceeding the limit of 256 MB"},
+		{"configured memory", Options{Scale: 8, MaxImageMB: 1, MaxContentLength: 2000}, "exceeding the limit of 1 MB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, msg := GenerateQR(row, t.TempDir(), tt.opts)
+			if status != "error" || !strings.Contains(msg, tt.want) {
+				t.Errorf("GenerateQR = %q, %q; want error containing %q", status, msg, tt.want)
+			}
+
+			ro, err := tt.opts.renderOptions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := RenderQR(content, ro); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("RenderQR error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// Errors come in the run's language, like the row messages.
+func TestErrorsLocalized(t *testing.T) {
+	opts := Options{Lang: LangIndonesian, Format: "gif"}
+	if err := opts.validate(); err == nil || err.Error() != "format output tidak didukung: gif" {
+		t.Errorf("validate = %v, want the Indonesian message", err)
+	}
+
+	row := map[string]string{
+		"NO IDENTITAS": "3201234567890001",
+		"NOMOR KK":     "3201230101010002",
+		"NAMA LENGKAP": "Siti Aminah",
+		"KODE QR":      strings.Repeat("3201234567890001 SITI AMINAH ", 50),
+	}
+	opts = Options{Lang: LangIndonesian, Scale: 128, MaxContentLength: 2000}
+	if status, msg := GenerateQR(row, t.TempDir(), opts); status != "error" || !strings.Contains(msg, "melebihi batas 16384px") {
+		t.Errorf("GenerateQR = %q, %q; want the Indonesian size error", status, msg)
+	}
+
+	rowErr := &RowError{Message: "Baris 2: gagal", lang: LangIndonesian}
+	if got, want := rowErr.Error(), "berhenti pada baris gagal pertama: Baris 2: gagal"; got != want {
+		t.Errorf("RowError = %q, want %q", got, want)
+	}
+}
+
+// FilenamePattern must find the image a template named, wherever the NIK
+// sits in the name, and only that row's image.
+func TestFilenamePattern(t *testing.T) {
+	row := map[string]string{
+		"NO IDENTITAS": "3201234567890001",
+		"NOMOR KK":     "3201230101010002",
+		"NAMA LENGKAP": "Siti Aminah",
+		"KODE QR":      "3201234567890001",
+	}
+	for _, template := range []string{"", "{name}_{nik}", "KTP {nik} ({kk})"} {
+		dir := t.TempDir()
+		if status, msg := GenerateQR(row, dir, Options{FilenameTemplate: template, FolderLevels: []string{}}); status != "ok" {
+			t.Fatalf("template %q: GenerateQR = %q, %q", template, status, msg)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("template %q: output %v, %v", template, entries, err)
+		}
+		name := entries[0].Name()
+
+		pattern, ok := FilenamePattern(template, "3201234567890001")
+		if !ok || !pattern.MatchString(name) {
+			t.Errorf("template %q: pattern %v doesn't match %s", template, pattern, name)
+		}
+		if other, _ := FilenamePattern(template, "3171011501900002"); other.MatchString(name) {
+			t.Errorf("template %q: another NIK's pattern matches %s", template, name)
+		}
+	}
+	if _, ok := FilenamePattern("{name}", "3201234567890001"); ok {
+		t.Error("template without {nik} gave a pattern")
+	}
+}
+
+// BenchmarkRunGenerateWorkers renders the same 200-row sheet with
+// different worker counts; rows/s should grow until the CPUs run out.
+func BenchmarkRunGenerateWorkers(b *testing.B) {
+	var sheet strings.Builder
+	sheet.WriteString("NO IDENTITAS,NOMOR KK,NAMA LENGKAP,KODE QR\n")
+	for i := range 200 {
+		fmt.Fprintf(&sheet, "%d,3201230101010002,Warga %d,https://example.com/warga/%d\n", 3201234567890000+i, i, i)
+	}
+	input := filepath.Join(b.TempDir(), "in.csv")
+	if err := os.WriteFile(input, []byte(sheet.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			out := b.TempDir()
+			opts := Options{Workers: workers, SkipZip: true, Scale: 8}
+			for i := 0; i < b.N; i++ {
+				result, err := RunGenerate(context.Background(), input, filepath.Join(out, strconv.Itoa(i)), opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if result.Generated != 200 {
+					b.Fatalf("generated %d codes, want 200", result.Generated)
+				}
+			}
+			b.ReportMetric(float64(200*b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+// BenchmarkZipFolder archives a folder of QR PNGs, encoded as RunGenerate
+// does by default, with each method. The ratio metric is the archive size
+// over the PNGs' total size.
+func BenchmarkZipFolder(b *testing.B) {
+	source := filepath.Join(b.TempDir(), "batch")
+	if err := os.Mkdir(source, 0o755); err != nil {
+		b.Fatal(err)
+	}
+	ro, err := Options{}.renderOptions()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var total int64
+	for i := range 40 {
+		img, err := RenderQR("https://example.com/warga/"+strconv.Itoa(3201234567890000+i), ro)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var buf bytes.Buffer
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		if err := enc.Encode(&buf, img); err != nil {
+			b.Fatal(err)
+		}
+		total += int64(buf.Len())
+		if err := os.WriteFile(filepath.Join(source, strconv.Itoa(i)+".png"), buf.Bytes(), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, method := range []string{ZipStore, ZipDeflate} {
+		b.Run(method, func(b *testing.B) {
+			target := filepath.Join(b.TempDir(), "batch.zip")
+			opts := Options{ZipCompression: method}
+			b.SetBytes(total)
+			for i := 0; i < b.N; i++ {
+				if _, err := zipFolder(context.Background(), source, target, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+			info, err := os.Stat(target)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(info.Size())/float64(total), "ratio")
+		})
+	}
+}
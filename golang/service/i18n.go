@@ -0,0 +1,418 @@
+package service
+
+import "fmt"
+
+// Languages for Options.Lang and the handlers' messages.
+const (
+	LangEnglish    = "en"
+	LangIndonesian = "id"
+)
+
+// SupportedLang reports whether lang has a message catalog.
+func SupportedLang(lang string) bool {
+	return lang == LangEnglish || lang == LangIndonesian
+}
+
+// Catalog maps a message key to its format string in each language.
+type Catalog map[string]map[string]string
+
+// Text formats the message key in lang, falling back to English when the
+// language has no translation, and to the key itself for unknown keys.
+func (c Catalog) Text(lang, key string, args ...any) string {
+	formats, ok := c[key]
+	if !ok {
+		return key
+	}
+	format, ok := formats[lang]
+	if !ok {
+		format = formats[LangEnglish]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// messages are the texts the service reports to users: row messages and
+// warnings in the Result, and errors such as invalid options, row limits
+// and OutputLimitError.
+var messages = Catalog{
+	"row": {
+		LangEnglish:    "Row %d",
+		LangIndonesian: "Baris %d",
+	},
+	"sheet_row": {
+		LangEnglish:    "Sheet %s row %d",
+		LangIndonesian: "Sheet %s baris %d",
+	},
+	"duplicate_content": {
+		LangEnglish:    "duplicate QR content (first seen on row %d)",
+		LangIndonesian: "isi QR ganda (pertama muncul di baris %d)",
+	},
+	"nik_length": {
+		LangEnglish:    "Invalid NIK: %s (expected %d digits, got %d)",
+		LangIndonesian: "NIK tidak valid: %s (seharusnya %d digit, terbaca %d)",
+	},
+	"kk_length": {
+		LangEnglish:    "Invalid KK: %s (expected %d digits, got %d)",
+		LangIndonesian: "Nomor KK tidak valid: %s (seharusnya %d digit, terbaca %d)",
+	},
+	"nik_structure_length": {
+		LangEnglish:    "Invalid NIK: %s (structure check needs 16 digits)",
+		LangIndonesian: "NIK tidak valid: %s (pemeriksaan struktur membutuhkan 16 digit)",
+	},
+	"nik_province": {
+		LangEnglish:    "Invalid NIK: %s (unknown province code %s)",
+		LangIndonesian: "NIK tidak valid: %s (kode provinsi %s tidak dikenal)",
+	},
+	"nik_regency": {
+		LangEnglish:    "Invalid NIK: %s (regency code 00)",
+		LangIndonesian: "NIK tidak valid: %s (kode kabupaten/kota 00)",
+	},
+	"nik_district": {
+		LangEnglish:    "Invalid NIK: %s (district code 00)",
+		LangIndonesian: "NIK tidak valid: %s (kode kecamatan 00)",
+	},
+	"nik_birth_month": {
+		LangEnglish:    "Invalid NIK: %s (birth month %s)",
+		LangIndonesian: "NIK tidak valid: %s (bulan lahir %s)",
+	},
+	"nik_birth_date": {
+		LangEnglish:    "Invalid NIK: %s (birth date %s-%s)",
+		LangIndonesian: "NIK tidak valid: %s (tanggal lahir %s-%s)",
+	},
+	"nik_serial": {
+		LangEnglish:    "Invalid NIK: %s (serial 0000)",
+		LangIndonesian: "NIK tidak valid: %s (nomor urut 0000)",
+	},
+	"card_empty": {
+		LangEnglish:    "contact card has no values",
+		LangIndonesian: "kartu kontak tidak berisi data",
+	},
+	"wifi_no_ssid": {
+		LangEnglish:    "WiFi SSID is empty",
+		LangIndonesian: "SSID WiFi kosong",
+	},
+	"wifi_auth": {
+		LangEnglish:    "unsupported WiFi auth type %q (expected WPA, WEP or nopass)",
+		LangIndonesian: "jenis autentikasi WiFi %q tidak didukung (gunakan WPA, WEP atau nopass)",
+	},
+	"wifi_no_password": {
+		LangEnglish:    "%s network needs a password",
+		LangIndonesian: "jaringan %s membutuhkan password",
+	},
+	"content_too_long": {
+		LangEnglish:    "QR content too long: %d bytes (limit %d)",
+		LangIndonesian: "Isi QR terlalu panjang: %d byte (batas %d)",
+	},
+	"content_over_capacity": {
+		LangEnglish:    "QR content too long: %d bytes (ECC level holds at most %d)",
+		LangIndonesian: "Isi QR terlalu panjang: %d byte (level ECC memuat paling banyak %d)",
+	},
+	"content_not_numeric": {
+		LangEnglish:    "QR content %q is not numeric",
+		LangIndonesian: "Isi QR %q bukan angka",
+	},
+	"content_not_alphanumeric": {
+		LangEnglish:    "QR content %q is not alphanumeric (0-9, A-Z, space, $%%*+-./:)",
+		LangIndonesian: "Isi QR %q bukan alfanumerik (0-9, A-Z, spasi, $%%*+-./:)",
+	},
+	"output_limit": {
+		LangEnglish:    "output size limit of %dMB exceeded after writing %.1fMB in %d images; the partial output was removed",
+		LangIndonesian: "batas ukuran output %dMB terlampaui setelah menulis %.1fMB dalam %d gambar; output sebagian telah dihapus",
+	},
+	"jpeg_quality_low": {
+		LangEnglish:    "JPEG quality %d is below %d; compression artifacts may make codes hard to scan",
+		LangIndonesian: "Kualitas JPEG %d di bawah %d; artefak kompresi dapat membuat kode sulit dipindai",
+	},
+	"small_modules": {
+		LangEnglish:    "modules are %dpx, below the %dpx many phone cameras need; increase the scale",
+		LangIndonesian: "modul berukuran %dpx, di bawah %dpx yang dibutuhkan banyak kamera ponsel; perbesar skala",
+	},
+	"light_foreground": {
+		LangEnglish:    "foreground %s is light; on a transparent background the codes only scan when printed over a dark color",
+		LangIndonesian: "warna depan %s terang; dengan latar transparan kode hanya terbaca bila dicetak di atas warna gelap",
+	},
+	"row_limit": {
+		LangEnglish:    "file has %d rows, exceeds limit %d",
+		LangIndonesian: "file berisi %d baris, melebihi batas %d",
+	},
+	"fail_fast": {
+		LangEnglish:    "stopped at the first failed row: %s",
+		LangIndonesian: "berhenti pada baris gagal pertama: %s",
+	},
+	"charset_guessed": {
+		LangEnglish:    "file is not valid UTF-8; it was read as %s",
+		LangIndonesian: "file bukan UTF-8 yang valid; dibaca sebagai %s",
+	},
+	"create_dir_failed": {
+		LangEnglish:    "Failed to create dir",
+		LangIndonesian: "Gagal membuat folder",
+	},
+	"save_failed": {
+		LangEnglish:    "Failed to save",
+		LangIndonesian: "Gagal menyimpan",
+	},
+	"encode_failed": {
+		LangEnglish:    "%s encode error",
+		LangIndonesian: "Gagal meng-encode %s",
+	},
+	"qr_create_failed": {
+		LangEnglish:    "Failed to create QR: %v",
+		LangIndonesian: "Gagal membuat QR: %v",
+	},
+	"image_too_large": {
+		LangEnglish:    "Image size %dpx exceeds limit of %dpx, use a smaller scale",
+		LangIndonesian: "Ukuran gambar %dpx melebihi batas %dpx, gunakan skala yang lebih kecil",
+	},
+	"image_memory": {
+		LangEnglish:    "Image %dx%dpx would need %d MB of memory, exceeding the limit of %d MB, use a smaller scale",
+		LangIndonesian: "Gambar %dx%dpx membutuhkan memori %d MB, melebihi batas %d MB, gunakan skala yang lebih kecil",
+	},
+	"lang_unsupported": {
+		LangEnglish:    "unsupported language: %s",
+		LangIndonesian: "bahasa tidak didukung: %s",
+	},
+	"wifi_combined": {
+		LangEnglish:    "WiFi mode can't be combined with a contact card or content columns",
+		LangIndonesian: "mode WiFi tidak dapat digabung dengan kartu kontak atau kolom isi",
+	},
+	"format_unsupported": {
+		LangEnglish:    "unsupported output format: %s",
+		LangIndonesian: "format output tidak didukung: %s",
+	},
+	"charset_unsupported": {
+		LangEnglish:    "unsupported charset: %s",
+		LangIndonesian: "charset tidak didukung: %s",
+	},
+	"delimiter_invalid": {
+		LangEnglish:    "invalid CSV delimiter: %q",
+		LangIndonesian: "pemisah CSV tidak valid: %q",
+	},
+	"jpeg_transparent": {
+		LangEnglish:    "JPEG has no transparency, use PNG, WebP or SVG for a transparent background",
+		LangIndonesian: "JPEG tidak mendukung transparansi, gunakan PNG, WebP atau SVG untuk latar transparan",
+	},
+	"jpeg_quality_range": {
+		LangEnglish:    "JPEG quality must be between 1 and 100, got %d",
+		LangIndonesian: "kualitas JPEG harus antara 1 dan 100, terbaca %d",
+	},
+	"output_limit_negative": {
+		LangEnglish:    "output size limit must not be negative, got %d",
+		LangIndonesian: "batas ukuran output tidak boleh negatif, terbaca %d",
+	},
+	"scale_range": {
+		LangEnglish:    "scale must be between %d and %d, got %d",
+		LangIndonesian: "skala harus antara %d dan %d, terbaca %d",
+	},
+	"filename_template": {
+		LangEnglish:    "filename template",
+		LangIndonesian: "template nama file",
+	},
+	"content_fallback": {
+		LangEnglish:    "content fallback",
+		LangIndonesian: "isi cadangan",
+	},
+	"template_no_field": {
+		LangEnglish:    "%s %q must reference at least one field",
+		LangIndonesian: "%s %q harus memuat paling sedikit satu kolom",
+	},
+	"template_unknown_field": {
+		LangEnglish:    "unknown field {%s} in %s, expected one of: %s",
+		LangIndonesian: "kolom {%s} pada %s tidak dikenal, gunakan salah satu dari: %s",
+	},
+	"template_braces": {
+		LangEnglish:    "unbalanced braces in %s %q",
+		LangIndonesian: "kurung kurawal pada %s %q tidak seimbang",
+	},
+	"content_mode_unsupported": {
+		LangEnglish:    "unsupported content mode: %s",
+		LangIndonesian: "mode isi tidak didukung: %s",
+	},
+	"style_unsupported": {
+		LangEnglish:    "unsupported module style: %s",
+		LangIndonesian: "gaya modul tidak didukung: %s",
+	},
+	"supersample_range": {
+		LangEnglish:    "supersample must be between 1 and %d, got %d",
+		LangIndonesian: "supersample harus antara 1 dan %d, terbaca %d",
+	},
+	"border_negative": {
+		LangEnglish:    "border must not be negative, got %d",
+		LangIndonesian: "border tidak boleh negatif, terbaca %d",
+	},
+	"padding_negative": {
+		LangEnglish:    "padding must not be negative, got %d",
+		LangIndonesian: "padding tidak boleh negatif, terbaca %d",
+	},
+	"zip_mode_unsupported": {
+		LangEnglish:    "unsupported zip mode: %s",
+		LangIndonesian: "mode zip tidak didukung: %s",
+	},
+	"zip_compression_unsupported": {
+		LangEnglish:    "unsupported zip compression: %s",
+		LangIndonesian: "kompresi zip tidak didukung: %s",
+	},
+	"png_compression_unsupported": {
+		LangEnglish:    "unsupported PNG compression: %s",
+		LangIndonesian: "kompresi PNG tidak didukung: %s",
+	},
+	"zip_part_negative": {
+		LangEnglish:    "zip part size must not be negative, got %d",
+		LangIndonesian: "ukuran bagian zip tidak boleh negatif, terbaca %d",
+	},
+	"zip_name_invalid": {
+		LangEnglish:    "invalid zip name: %q",
+		LangIndonesian: "nama zip tidak valid: %q",
+	},
+	"cleanup_without_zip": {
+		LangEnglish:    "cleanup would delete the output when zip mode is %s",
+		LangIndonesian: "pembersihan akan menghapus output bila mode zip %s",
+	},
+	"folder_layout_unsupported": {
+		LangEnglish:    "unsupported folder layout: %s",
+		LangIndonesian: "susunan folder tidak didukung: %s",
+	},
+	"zip_per_kecamatan_levels": {
+		LangEnglish:    "zip mode %s needs at least one folder level",
+		LangIndonesian: "mode zip %s membutuhkan paling sedikit satu level folder",
+	},
+	"folder_level_empty": {
+		LangEnglish:    "folder levels must not be empty",
+		LangIndonesian: "level folder tidak boleh kosong",
+	},
+	"sheet_and_sheets": {
+		LangEnglish:    "set either a sheet or several sheets, not both",
+		LangIndonesian: "pilih satu sheet atau beberapa sheet, bukan keduanya",
+	},
+	"folder_placeholder_invalid": {
+		LangEnglish:    "invalid folder placeholder: %q",
+		LangIndonesian: "pengganti folder tidak valid: %q",
+	},
+	"content_column_empty": {
+		LangEnglish:    "content columns must not be empty",
+		LangIndonesian: "kolom isi tidak boleh kosong",
+	},
+	"content_column_clash": {
+		LangEnglish:    "content columns %q and %q would produce the same file names",
+		LangIndonesian: "kolom isi %q dan %q akan menghasilkan nama file yang sama",
+	},
+	"write_retries_negative": {
+		LangEnglish:    "write retries must not be negative, got %d",
+		LangIndonesian: "jumlah percobaan ulang tidak boleh negatif, terbaca %d",
+	},
+	"file_modes_invalid": {
+		LangEnglish:    "directory and file modes must be permission bits (at most 0777), got %#o and %#o",
+		LangIndonesian: "mode direktori dan file harus berupa bit izin (paling besar 0777), terbaca %#o dan %#o",
+	},
+	"dir_mode_owner": {
+		LangEnglish:    "directory mode %#o must let the owner read, write and enter folders",
+		LangIndonesian: "mode direktori %#o harus mengizinkan pemilik membaca, menulis dan membuka folder",
+	},
+	"id_length_negative": {
+		LangEnglish:    "NIK and KK lengths must be positive",
+		LangIndonesian: "panjang NIK dan KK harus positif",
+	},
+	"strict_nik_length": {
+		LangEnglish:    "strict NIK validation needs %d-digit NIKs, got length %d",
+		LangIndonesian: "validasi NIK ketat membutuhkan NIK %d digit, terbaca panjang %d",
+	},
+	"pdf_columns_range": {
+		LangEnglish:    "PDF columns must be between 1 and %d, got %d",
+		LangIndonesian: "kolom PDF harus antara 1 dan %d, terbaca %d",
+	},
+	"workers_range": {
+		LangEnglish:    "workers must be between 1 and %d, got %d",
+		LangIndonesian: "jumlah worker harus antara 1 dan %d, terbaca %d",
+	},
+	"logo_ecc": {
+		LangEnglish:    "a logo requires ECC level high or highest, got %s",
+		LangIndonesian: "logo membutuhkan level ECC high atau highest, terbaca %s",
+	},
+	"ecc_unsupported": {
+		LangEnglish:    "unsupported ECC level: %s",
+		LangIndonesian: "level ECC tidak didukung: %s",
+	},
+	"fg_color": {
+		LangEnglish:    "foreground color",
+		LangIndonesian: "warna depan",
+	},
+	"bg_color": {
+		LangEnglish:    "background color",
+		LangIndonesian: "warna latar",
+	},
+	"gradient_color": {
+		LangEnglish:    "gradient color",
+		LangIndonesian: "warna gradien",
+	},
+	"eye_color": {
+		LangEnglish:    "eye color",
+		LangIndonesian: "warna mata",
+	},
+	"eye_border_color": {
+		LangEnglish:    "eye border color",
+		LangIndonesian: "warna bingkai mata",
+	},
+	"color_invalid": {
+		LangEnglish:    "%s: invalid hex color: %q",
+		LangIndonesian: "%s: warna hex %q tidak valid",
+	},
+	"contrast_low": {
+		LangEnglish:    "contrast ratio between %s and %s is %.2f, minimum is %.1f",
+		LangIndonesian: "rasio kontras antara %s dan %s adalah %.2f, minimal %.1f",
+	},
+	"gradient_direction_unsupported": {
+		LangEnglish:    "unsupported gradient direction: %s",
+		LangIndonesian: "arah gradien tidak didukung: %s",
+	},
+	"strict_iso_border": {
+		LangEnglish:    "strict ISO mode needs a %d-module border, got %d",
+		LangIndonesian: "mode ISO ketat membutuhkan border %d modul, terbaca %d",
+	},
+	"strict_iso_style": {
+		LangEnglish:    "strict ISO mode needs square modules, got %s",
+		LangIndonesian: "mode ISO ketat membutuhkan modul persegi, terbaca %s",
+	},
+	"strict_iso_colors": {
+		LangEnglish:    "strict ISO mode needs black modules on a white background",
+		LangIndonesian: "mode ISO ketat membutuhkan modul hitam di atas latar putih",
+	},
+	"strict_iso_logo": {
+		LangEnglish:    "strict ISO mode does not allow a logo",
+		LangIndonesian: "mode ISO ketat tidak mengizinkan logo",
+	},
+	"strict_iso_gradient": {
+		LangEnglish:    "strict ISO mode does not allow a gradient",
+		LangIndonesian: "mode ISO ketat tidak mengizinkan gradien",
+	},
+	"strict_iso_eye": {
+		LangEnglish:    "strict ISO mode does not allow eye colors",
+		LangIndonesian: "mode ISO ketat tidak mengizinkan warna mata",
+	},
+	"card_phone": {
+		LangEnglish:    "card phone",
+		LangIndonesian: "telepon kartu",
+	},
+	"number_format_unsupported": {
+		LangEnglish:    "unsupported %s format: %s (expected %s, %s or %s)",
+		LangIndonesian: "format %s tidak didukung: %s (gunakan %s, %s atau %s)",
+	},
+	"card_format_unsupported": {
+		LangEnglish:    "unsupported contact card format: %s (expected %s or %s)",
+		LangIndonesian: "format kartu kontak tidak didukung: %s (gunakan %s atau %s)",
+	},
+	"card_field_unknown": {
+		LangEnglish:    "unknown contact card field %q (expected one of: %s)",
+		LangIndonesian: "kolom kartu kontak %q tidak dikenal (gunakan salah satu dari: %s)",
+	},
+	"card_content_columns": {
+		LangEnglish:    "a contact card can't be combined with content columns",
+		LangIndonesian: "kartu kontak tidak dapat digabung dengan kolom isi",
+	},
+	"column_key_unknown": {
+		LangEnglish:    "unknown column mapping key %q (expected one of: %s)",
+		LangIndonesian: "kunci pemetaan kolom %q tidak dikenal (gunakan salah satu dari: %s)",
+	},
+}
+
+// text formats a service message in the run's language.
+func (o Options) text(key string, args ...any) string {
+	return messages.Text(o.Lang, key, args...)
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var labelFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+var contentFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(gomono.TTF)
+})
+
+// maxContentLines caps the QR content printed under a code; longer
+// values end in an ellipsis.
+const maxContentLines = 3
+
+// labelBand is the height of the caption area added under a code that is
+// finalSize pixels wide.
+func labelBand(finalSize int) int {
+	return max(finalSize/6, 12)
+}
+
+func labelFace(band int) (font.Face, error) {
+	f, err := labelFont()
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    float64(band) * 0.55,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// fitLabel shortens text with an ellipsis until it is at most width
+// pixels wide.
+func fitLabel(face font.Face, text string, width int) string {
+	limit := fixed.I(width)
+	if font.MeasureString(face, text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		short := strings.TrimSpace(string(runes)) + "…"
+		if font.MeasureString(face, short) <= limit {
+			return short
+		}
+	}
+	return ""
+}
+
+// addLabel returns img extended downwards by a caption band with text
+// centered in it. The code itself is left untouched.
+func addLabel(img *image.RGBA, text string, fg, bg color.RGBA) (*image.RGBA, error) {
+	size := img.Bounds().Dx()
+	band := labelBand(size)
+	face, err := labelFace(band)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load label font: %v", err)
+	}
+	defer face.Close()
+
+	out := image.NewRGBA(image.Rect(0, 0, size, size+band))
+	draw.Draw(out, out.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+	draw.Draw(out, img.Bounds(), img, image.Point{}, draw.Src)
+
+	text = fitLabel(face, text, size-band/2)
+	m := face.Metrics()
+	d := font.Drawer{
+		Dst:  out,
+		Src:  &image.Uniform{fg},
+		Face: face,
+		Dot: fixed.Point26_6{
+			X: (fixed.I(size) - font.MeasureString(face, text)) / 2,
+			Y: fixed.I(size + (band+m.Ascent.Ceil()-m.Descent.Ceil())/2),
+		},
+	}
+	d.DrawString(text)
+	return out, nil
+}
+
+// writeSVGLabel writes the caption as a text element placed like
+// addLabel's, truncated using the metrics of the same font.
+func writeSVGLabel(bw *bufio.Writer, text string, finalSize int, fg color.RGBA) error {
+	band := labelBand(finalSize)
+	face, err := labelFace(band)
+	if err != nil {
+		return fmt.Errorf("failed to load label font: %v", err)
+	}
+	defer face.Close()
+
+	text = fitLabel(face, text, finalSize-band/2)
+	m := face.Metrics()
+	fmt.Fprintf(bw, `<text x="%d" y="%d" font-family="Go, Arial, sans-serif" font-size="%.1f" text-anchor="middle" fill="%s">`,
+		finalSize/2, finalSize+(band+m.Ascent.Ceil()-m.Descent.Ceil())/2, float64(band)*0.55, hexColor(fg))
+	xml.EscapeText(bw, []byte(text))
+	bw.WriteString("</text>\n")
+	return nil
+}
+
+// contentLineHeight is the height of one line of QR content under a code
+// that is finalSize pixels wide.
+func contentLineHeight(finalSize int) int {
+	return max(finalSize/20, 10)
+}
+
+func contentFace(lineHeight int) (font.Face, error) {
+	f, err := contentFont()
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    float64(lineHeight) * 0.75,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// contentLines breaks text into lines at most width pixels wide, at any
+// character since QR values rarely have spaces, and cuts it off after
+// maxContentLines.
+func contentLines(face font.Face, text string, width int) []string {
+	limit := fixed.I(width)
+	var lines []string
+	runes := []rune(text)
+	for len(runes) > 0 {
+		if len(lines) == maxContentLines-1 {
+			return append(lines, fitLabel(face, string(runes), width))
+		}
+		n := 1
+		for n < len(runes) && font.MeasureString(face, string(runes[:n+1])) <= limit {
+			n++
+		}
+		lines = append(lines, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return lines
+}
+
+// contentLayout is the face, lines and line height for printing text
+// under a code finalSize pixels wide. The caller closes the face.
+func contentLayout(text string, finalSize int) (font.Face, []string, int, error) {
+	lineHeight := contentLineHeight(finalSize)
+	face, err := contentFace(lineHeight)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to load content font: %v", err)
+	}
+	return face, contentLines(face, text, finalSize-lineHeight), lineHeight, nil
+}
+
+// contentBand is the height added under a code finalSize pixels wide to
+// print text: its lines plus half a line of padding.
+func contentBand(text string, finalSize int) (int, error) {
+	face, lines, lineHeight, err := contentLayout(text, finalSize)
+	if err != nil {
+		return 0, err
+	}
+	face.Close()
+	return len(lines)*lineHeight + lineHeight/2, nil
+}
+
+// addContent returns img extended downwards by the QR content in a
+// monospace font, each line centered. Everything above, the code and any
+// label, is copied unchanged.
+func addContent(img *image.RGBA, text string, fg, bg color.RGBA) (*image.RGBA, error) {
+	width, top := img.Bounds().Dx(), img.Bounds().Dy()
+	face, lines, lineHeight, err := contentLayout(text, width)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	out := image.NewRGBA(image.Rect(0, 0, width, top+len(lines)*lineHeight+lineHeight/2))
+	draw.Draw(out, out.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+	draw.Draw(out, img.Bounds(), img, image.Point{}, draw.Src)
+
+	ascent := face.Metrics().Ascent.Ceil()
+	for i, line := range lines {
+		d := font.Drawer{
+			Dst:  out,
+			Src:  &image.Uniform{fg},
+			Face: face,
+			Dot: fixed.Point26_6{
+				X: (fixed.I(width) - font.MeasureString(face, line)) / 2,
+				Y: fixed.I(top + i*lineHeight + ascent),
+			},
+		}
+		d.DrawString(line)
+	}
+	return out, nil
+}
+
+// writeSVGContent writes the QR content as text lines starting at top,
+// placed and broken like addContent's.
+func writeSVGContent(bw *bufio.Writer, text string, finalSize, top int, fg color.RGBA) error {
+	face, lines, lineHeight, err := contentLayout(text, finalSize)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	ascent := face.Metrics().Ascent.Ceil()
+	for i, line := range lines {
+		fmt.Fprintf(bw, `<text x="%d" y="%d" font-family="Go Mono, monospace" font-size="%.1f" text-anchor="middle" fill="%s">`,
+			finalSize/2, top+i*lineHeight+ascent, float64(lineHeight)*0.75, hexColor(fg))
+		xml.EscapeText(bw, []byte(line))
+		bw.WriteString("</text>\n")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// logoRatio is the share of the final image width taken up by the logo.
+// At ~20% the overlay stays well within what ECC level H can recover.
+const logoRatio = 0.2
+
+func loadLogo(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open logo: %v", err)
+	}
+	defer f.Close()
+
+	logo, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode logo: %v", err)
+	}
+	return logo, nil
+}
+
+// logoRect returns the centered area the logo occupies on a canvas of
+// finalSize pixels, keeping the logo's aspect ratio.
+func logoRect(logo image.Image, finalSize int) image.Rectangle {
+	b := logo.Bounds()
+	box := int(float64(finalSize) * logoRatio)
+	w, h := box, box
+	if b.Dx() > b.Dy() {
+		h = box * b.Dy() / b.Dx()
+	} else if b.Dy() > b.Dx() {
+		w = box * b.Dx() / b.Dy()
+	}
+	x := (finalSize - w) / 2
+	y := (finalSize - h) / 2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// drawLogo scales the logo into the center of img, alpha-blending it over
+// the modules underneath.
+func drawLogo(img xdraw.Image, logo image.Image, finalSize int) {
+	xdraw.CatmullRom.Scale(img, logoRect(logo, finalSize), logo, logo.Bounds(), xdraw.Over, nil)
+}
+
+// writeSVGLogo embeds the logo as a PNG data URI positioned like drawLogo.
+func writeSVGLogo(w io.Writer, logo image.Image, finalSize int) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo); err != nil {
+		return err
+	}
+	r := logoRect(logo, finalSize)
+	_, err := fmt.Fprintf(w, `<image x="%d" y="%d" width="%d" height="%d" href="data:image/png;base64,%s"/>`+"\n",
+		r.Min.X, r.Min.Y, r.Dx(), r.Dy(), base64.StdEncoding.EncodeToString(buf.Bytes()))
+	return err
+}
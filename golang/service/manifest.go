@@ -0,0 +1,71 @@
+package service
+
+import (
+	"encoding/csv"
+	"io"
+	"path/filepath"
+)
+
+// ManifestFilename is the name of the manifest written into the output.
+const ManifestFilename = "manifest.csv"
+
+// writeManifest writes one CSV line per source row linking the person to
+// the image produced for them. It re-reads the spreadsheet rather than
+// keeping every row around, and must run after all workers finish so the
+// statuses in result are final.
+func writeManifest(w io.Writer, filePath string, result *Result, opts Options) error {
+	src, err := openRows(filePath, opts)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"NO IDENTITAS", "NOMOR KK", "NAMA LENGKAP", "KECAMATAN", "KELURAHAN", "FILE", "STATUS", "KETERANGAN"})
+
+	for i := 0; i < len(result.Rows); i++ {
+		row, err := src.Next()
+		if err != nil {
+			return err
+		}
+		rr := result.Rows[i]
+		file := ""
+		if plan, _, _ := planRow(row, opts); plan != nil {
+			// a skip whose message isn't the file name (e.g. duplicate
+			// content) has no image of its own
+			if rr.Status == "ok" || rr.Status == "skip" && rr.Message == plan.Filename {
+				file = filepath.ToSlash(filepath.Join(plan.Dir, plan.Filename))
+			}
+		}
+		message := rr.Message
+		if file != "" {
+			message = ""
+		}
+		cw.Write([]string{
+			row.Values["NO IDENTITAS"],
+			row.Values["NOMOR KK"],
+			row.Values["NAMA LENGKAP"],
+			row.Values["KECAMATAN"],
+			row.Values["KELURAHAN"],
+			file,
+			rr.Status,
+			message,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func saveManifest(outputFolder, filePath string, result *Result, opts Options) error {
+	f, err := opts.createFile(filepath.Join(outputFolder, ManifestFilename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeManifest(f, filePath, result, opts); err != nil {
+		return err
+	}
+	return f.Close()
+}
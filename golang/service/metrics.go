@@ -0,0 +1,82 @@
+package service
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// MetricsRegistry holds the generation counters and timings, for the
+// /metrics endpoint to serve.
+var MetricsRegistry = prometheus.NewRegistry()
+
+// rowStatuses maps the statuses returned by generateQR to the row
+// counter's labels.
+var rowStatuses = map[string]string{
+	"ok":      "generated",
+	"skip":    "skipped",
+	"invalid": "invalid",
+	"valid":   "valid",
+	"error":   "error",
+}
+
+var (
+	metricsFactory = promauto.With(MetricsRegistry)
+
+	rowsProcessed = metricsFactory.NewCounter(prometheus.CounterOpts{
+		Name: "qr_rows_processed_total",
+		Help: "Spreadsheet rows processed.",
+	})
+	rowsTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "qr_rows_total",
+		Help: "Spreadsheet rows processed, by outcome.",
+	}, []string{"status"})
+	jobsTotal = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "qr_jobs_total",
+		Help: "Generation runs finished, by result.",
+	}, []string{"result"})
+	jobDuration = metricsFactory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "qr_job_duration_seconds",
+		Help:    "Duration of whole generation runs.",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	})
+	renderDuration = metricsFactory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "qr_render_duration_seconds",
+		Help:    "Time to render and save a single QR image.",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	})
+)
+
+func init() {
+	// Export every label from the start, so rates work before the
+	// first row of each kind.
+	for _, label := range rowStatuses {
+		rowsTotal.WithLabelValues(label)
+	}
+	jobsTotal.WithLabelValues("ok")
+	jobsTotal.WithLabelValues("error")
+}
+
+// observeRow counts a processed row by its status.
+func observeRow(status string) {
+	rowsProcessed.Inc()
+	if label, ok := rowStatuses[status]; ok {
+		rowsTotal.WithLabelValues(label).Inc()
+	}
+}
+
+// observeJob records a finished run and how long it took.
+func observeJob(d time.Duration, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	jobsTotal.WithLabelValues(outcome).Inc()
+	jobDuration.Observe(d.Seconds())
+}
+
+// observeRender records the time taken to render and save one image.
+func observeRender(d time.Duration) {
+	renderDuration.Observe(d.Seconds())
+}
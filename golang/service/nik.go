@@ -0,0 +1,55 @@
+package service
+
+import "strconv"
+
+// NIK layout: PP KK CC DDMMYY SSSS, i.e. province, regency and district
+// codes, the holder's birth date (day + 40 for women) and a serial.
+
+// provinceCodes are the valid first two digits of a NIK, including the
+// Papua provinces created in 2022.
+var provinceCodes = map[string]bool{
+	"11": true, "12": true, "13": true, "14": true, "15": true, "16": true, "17": true, "18": true, "19": true,
+	"21": true,
+	"31": true, "32": true, "33": true, "34": true, "35": true, "36": true,
+	"51": true, "52": true, "53": true,
+	"61": true, "62": true, "63": true, "64": true, "65": true,
+	"71": true, "72": true, "73": true, "74": true, "75": true, "76": true,
+	"81": true, "82": true,
+	"91": true, "92": true, "93": true, "94": true, "95": true, "96": true,
+}
+
+// daysInMonth allows 29 February, as the century of the birth year isn't
+// encoded.
+var daysInMonth = [13]int{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// checkNIKStructure checks a cleaned 16-digit NIK against the layout
+// above, returning in lang why it can't be a real NIK, or "" when it can.
+func checkNIKStructure(nik, lang string) string {
+	if len(nik) != 16 {
+		return messages.Text(lang, "nik_structure_length", nik)
+	}
+	if !provinceCodes[nik[0:2]] {
+		return messages.Text(lang, "nik_province", nik, nik[0:2])
+	}
+	if nik[2:4] == "00" {
+		return messages.Text(lang, "nik_regency", nik)
+	}
+	if nik[4:6] == "00" {
+		return messages.Text(lang, "nik_district", nik)
+	}
+	day, _ := strconv.Atoi(nik[6:8])
+	month, _ := strconv.Atoi(nik[8:10])
+	if day > 40 {
+		day -= 40
+	}
+	if month < 1 || month > 12 {
+		return messages.Text(lang, "nik_birth_month", nik, nik[8:10])
+	}
+	if day < 1 || day > daysInMonth[month] {
+		return messages.Text(lang, "nik_birth_date", nik, nik[6:8], nik[8:10])
+	}
+	if nik[12:16] == "0000" {
+		return messages.Text(lang, "nik_serial", nik)
+	}
+	return ""
+}
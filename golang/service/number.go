@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"strings"
+)
+
+// Number formats for FormatNumber, chosen per field.
+const (
+	// NumberDigits keeps digits only, as CleanNumber does for NIK and KK.
+	NumberDigits = "digits"
+	// NumberPlus keeps digits and a leading "+", e.g. "+628123456789".
+	NumberPlus = "plus"
+	// NumberGrouped also keeps the spaces, hyphens, dots and parentheses
+	// grouping the digits, e.g. "+62 812-3456-789".
+	NumberGrouped = "grouped"
+)
+
+// groupingChars are the separators NumberGrouped keeps.
+const groupingChars = " -.()"
+
+// FormatNumber cleans a number cell in the given format. Anything else,
+// such as letters or a "+" that doesn't lead, is dropped. It returns ""
+// when value has no digits.
+func FormatNumber(value, format string) string {
+	value = strings.TrimSpace(value)
+	var b strings.Builder
+	digits := false
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+			digits = true
+		case format == NumberGrouped && strings.ContainsRune(groupingChars, r):
+			b.WriteRune(r)
+		}
+	}
+	if !digits {
+		return ""
+	}
+	number := b.String()
+	if format == NumberGrouped {
+		// one space per gap, and no separators dangling at the ends
+		number = strings.Trim(strings.Join(strings.Fields(number), " "), " -.")
+	}
+	if format != NumberDigits && strings.HasPrefix(value, "+") {
+		number = "+" + number
+	}
+	return number
+}
+
+func (o Options) validateNumberFormat(kind, format string) error {
+	switch format {
+	case "", NumberDigits, NumberPlus, NumberGrouped:
+		return nil
+	}
+	return errors.New(o.text("number_format_unsupported", o.text(kind), format, NumberDigits, NumberPlus, NumberGrouped))
+}
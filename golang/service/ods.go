@@ -0,0 +1,188 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// odsTable is one sheet of an OpenDocument spreadsheet as plain text.
+type odsTable struct {
+	Name string
+	Rows [][]string
+}
+
+// readODS reads the given sheet of an .ods file, or the first one when
+// sheet is empty.
+func readODS(filePath string, columns map[string]string, content []string, sheet string) (*sliceRows, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	f, err := zr.Open("content.xml")
+	if err != nil {
+		return nil, fmt.Errorf("invalid ods file: %v", err)
+	}
+	defer f.Close()
+
+	tables, err := parseODSContent(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ods file: %v", err)
+	}
+
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.Name
+	}
+	name, err := resolveSheet(names, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, t := range tables {
+		if t.Name == name {
+			rows = t.Rows
+			break
+		}
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("empty ods file")
+	}
+	return tableRows(rows, columns, content)
+}
+
+// parseODSContent walks content.xml and collects the text of every cell.
+// Repeated rows and cells are expanded, except for the trailing blank
+// ones LibreOffice uses to pad a sheet to its full size.
+func parseODSContent(r io.Reader) ([]odsTable, error) {
+	var (
+		tables     []odsTable
+		row        []string
+		rowRepeat  int
+		blankRows  int
+		blankCells int
+		cell       strings.Builder
+		cellValue  string
+		cellRepeat int
+		inCell     bool
+		paragraphs int
+	)
+
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "table":
+				tables = append(tables, odsTable{Name: odsAttr(t, "name")})
+				blankRows = 0
+			case "table-row":
+				row = nil
+				rowRepeat = odsRepeat(t, "number-rows-repeated")
+				blankCells = 0
+			case "table-cell", "covered-table-cell":
+				inCell = true
+				cell.Reset()
+				paragraphs = 0
+				cellRepeat = odsRepeat(t, "number-columns-repeated")
+				// numbers keep their full precision in office:value,
+				// whatever the display format does to the text
+				cellValue = ""
+				if odsAttr(t, "value-type") == "float" {
+					cellValue = odsAttr(t, "value")
+				}
+			case "p":
+				if inCell && paragraphs > 0 {
+					cell.WriteByte('\n')
+				}
+				paragraphs++
+			case "s":
+				if inCell {
+					n := odsRepeat(t, "c")
+					cell.WriteString(strings.Repeat(" ", n))
+				}
+			case "tab":
+				if inCell {
+					cell.WriteByte('\t')
+				}
+			case "line-break":
+				if inCell {
+					cell.WriteByte('\n')
+				}
+			}
+		case xml.CharData:
+			if inCell {
+				cell.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "table-cell", "covered-table-cell":
+				inCell = false
+				value := cellValue
+				if value == "" {
+					value = cell.String()
+				}
+				if value == "" {
+					blankCells += cellRepeat
+					continue
+				}
+				for ; blankCells > 0; blankCells-- {
+					row = append(row, "")
+				}
+				for i := 0; i < cellRepeat; i++ {
+					row = append(row, value)
+				}
+			case "table-row":
+				if len(tables) == 0 {
+					continue
+				}
+				table := &tables[len(tables)-1]
+				if len(row) == 0 {
+					blankRows += rowRepeat
+					continue
+				}
+				for ; blankRows > 0; blankRows-- {
+					table.Rows = append(table.Rows, nil)
+				}
+				for i := 0; i < rowRepeat; i++ {
+					table.Rows = append(table.Rows, row)
+				}
+			}
+		}
+	}
+	return tables, nil
+}
+
+// odsAttr returns the attribute with the given local name, ignoring its
+// namespace.
+func odsAttr(el xml.StartElement, local string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// odsRepeat reads a repeat count attribute, defaulting to 1.
+func odsRepeat(el xml.StartElement, local string) int {
+	n, err := strconv.Atoi(odsAttr(el, local))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
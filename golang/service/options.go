@@ -0,0 +1,937 @@
+package service
+
+import (
+	"archive/zip"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Supported output formats.
+const (
+	FormatPNG  = "png"
+	FormatSVG  = "svg"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+)
+
+// JPEG quality settings. Below MinSafeJPEGQuality compression artifacts
+// around module edges start to hurt scannability.
+const (
+	DefaultJPEGQuality = 90
+	MinSafeJPEGQuality = 75
+)
+
+// Zip modes: one archive for the whole run, one per KECAMATAN folder, or
+// none, leaving just the images.
+const (
+	ZipSingle       = "single"
+	ZipPerKecamatan = "per-kecamatan"
+	ZipNone         = "none"
+)
+
+// Folder layouts: nested per FolderLevels, per QR version only, or per
+// QR version with the FolderLevels folders inside. Version folders group
+// codes of the same module count, which print at the same physical size.
+const (
+	LayoutColumns        = "columns"
+	LayoutVersion        = "version"
+	LayoutVersionColumns = "version-columns"
+)
+
+// Zip compression methods. ZipStore zips about three times faster, but
+// QR PNGs are mostly flat color and deflate still shrinks them to around
+// a tenth, so stored archives are much larger.
+const (
+	ZipDeflate = "deflate"
+	ZipStore   = "store"
+)
+
+// PNG compression levels, from smallest files to fastest encoding. On
+// a code at the default scale PNGBest takes about twice as long as
+// PNGDefault and three times as long as PNGSpeed, for files five to six
+// times smaller. PNGNone writes the pixels uncompressed.
+const (
+	PNGBest    = "best"
+	PNGDefault = "default"
+	PNGSpeed   = "speed"
+	PNGNone    = "none"
+)
+
+// Content modes restrict what a QR payload may contain. go-qrcode always
+// picks the densest encoding itself; a mode rejects rows that would not
+// fit it instead of silently falling back to a larger code.
+const (
+	ContentAuto         = "auto"
+	ContentNumeric      = "numeric"
+	ContentAlphanumeric = "alphanumeric"
+	ContentByte         = "byte"
+)
+
+// Module scale bounds, in pixels per module.
+const (
+	DefaultScale = 64
+	MinScale     = 1
+	MaxScale     = 128
+)
+
+// DefaultMinModulePixels is the smallest module, in pixels, that phone
+// cameras reliably resolve when an image is shown or printed at 1:1.
+const DefaultMinModulePixels = 4
+
+// DefaultBorder is the quiet zone width in modules recommended by ISO/IEC
+// 18004.
+const DefaultBorder = 4
+
+// DefaultFolderLevels nests images by KECAMATAN, then KELURAHAN.
+var DefaultFolderLevels = []string{"KECAMATAN", "KELURAHAN"}
+
+// DefaultIDLength is the expected digit count of NIK and KK numbers.
+const DefaultIDLength = 16
+
+// MaxWorkers caps the number of rows rendered concurrently.
+const MaxWorkers = 64
+
+// DefaultMaxRows caps the data rows accepted from one file.
+const DefaultMaxRows = 100000
+
+// DefaultMaxContentLength caps the encoded content of one QR, in bytes.
+// Content must also fit the QR capacity for the ECC level, which is
+// larger at every level.
+const DefaultMaxContentLength = 500
+
+// DefaultMaxDimension caps the width/height of a rendered image in pixels.
+// It is large enough that the default scale never hits it for content
+// within the length limit.
+const DefaultMaxDimension = 16384
+
+// DefaultMaxImageMB caps the memory of one rendered raster image. It fits
+// content within DefaultMaxContentLength at the default scale.
+const DefaultMaxImageMB = 256
+
+// Options controls how QR codes are generated.
+type Options struct {
+	// FilePath is the spreadsheet to read (.xlsx, .xls, .ods, .csv, .tsv
+	// or .txt). Used by Generate; RunGenerate and OpenZipStream take it
+	// as an argument instead.
+	FilePath string
+	// SourceName is the spreadsheet's name as recorded in PNG metadata,
+	// for when FilePath is a copy saved under another name. Empty means
+	// the base name of FilePath.
+	SourceName string
+	// OutputFolder receives the images, nested per FolderLevels. Used by
+	// Generate; not needed with ValidateOnly.
+	OutputFolder string
+	// Format is the output image format: FormatPNG (default), FormatSVG,
+	// FormatJPEG or FormatWebP (lossless).
+	Format string
+	// JPEGQuality is the JPEG encoder quality (1-100). Zero means
+	// DefaultJPEGQuality.
+	JPEGQuality int
+	// PNGCompression is PNGBest (default), PNGDefault, PNGSpeed or
+	// PNGNone, trading file size for encoding time.
+	PNGCompression string
+	// FgColor and BgColor are hex colors ("#1a2b3c") for the modules and
+	// the background. Empty means black on white. A row's WARNA cell, when
+	// it holds a readable hex color, overrides FgColor for that row.
+	FgColor string
+	BgColor string
+	// TransparentBg leaves the background fully transparent instead of
+	// BgColor, for printing over colored backgrounds. The contrast check
+	// then can't apply, so a light FgColor only gets a warning. PNG, WebP
+	// and SVG only.
+	TransparentBg bool
+	// StrictISO guarantees plain ISO/IEC 18004 output: a DefaultBorder
+	// quiet zone, square modules, black on white, with no logo, gradient
+	// or eye colors. Setting any of those otherwise fails validation, and
+	// WARNA row colors are ignored.
+	StrictISO bool
+	// Columns maps internal column keys (ColNIK, ColQR, ...) to the
+	// spreadsheet's own header names. Unmapped keys use the default
+	// Indonesian headers.
+	Columns map[string]string
+	// ContentColumns lists the columns (header names or column keys) that
+	// each hold a QR payload. Every row then yields one image per column,
+	// named with the column appended, and Result counts images rather
+	// than rows. Empty means the single ColQR column.
+	ContentColumns []string
+	// Card encodes a contact card built from CardColumns instead of the
+	// QR column: CardMECARD or CardVCard. ContentPrefix and ContentSuffix
+	// don't apply, and {qr} in file names is the card's name. Empty
+	// means off.
+	Card string
+	// CardColumns maps card fields (CardName, CardPhone, CardEmail,
+	// CardOrg) to the columns, header names or column keys, they are read
+	// from. The name defaults to ColName; other fields are left out unless
+	// mapped.
+	CardColumns map[string]string
+	// CardPhoneFormat is how the card's phone number is cleaned:
+	// NumberPlus (default) keeps a leading "+", NumberDigits keeps digits
+	// only and NumberGrouped keeps the grouping too.
+	CardPhoneFormat string
+	// WiFi encodes a network join payload ("WIFI:T:WPA;S:...;P:...;;")
+	// built from the SSID, PASSWORD and AUTH columns instead of the QR
+	// column. AUTH is WPA (also WPA2, WPA3), WEP or nopass; rows with
+	// another type, or without the password it needs, are invalid.
+	// ContentPrefix and ContentSuffix don't apply, and {qr} in file
+	// names is the SSID.
+	WiFi bool
+	// Charset is the character set of CSV input: CharsetAuto (default),
+	// CharsetUTF8, CharsetWindows1252 or CharsetLatin1. Excel and ODS
+	// files are always Unicode.
+	Charset string
+	// Delimiter is the CSV field separator: a single character, or "tab".
+	// Empty means auto-detect from the header line.
+	Delimiter string
+	// Sheet selects the Excel worksheet by name or 1-based position.
+	// Empty means the first sheet.
+	Sheet string
+	// Sheets, instead of Sheet, reads several Excel worksheets one after
+	// another as one dataset, each with its own header row; a single "*"
+	// reads them all. Rows then report their sheet.
+	Sheets []string
+	// ECC is the error correction level: "low", "medium", "high" or
+	// "highest" (default).
+	ECC string
+	// LogoPath is an optional image composited in the center of each QR.
+	// Because the logo covers modules, ECC must be at least "high".
+	LogoPath string
+	// Scale is the size of one module in pixels (MinScale..MaxScale).
+	// Zero means DefaultScale.
+	Scale int
+	// MinModulePixels is the module size below which raster images are
+	// flagged as hard to scan; zero means DefaultMinModulePixels. Every
+	// image of a run shares the module size, so it is reported once, as a
+	// Result warning, or rejected when StrictModuleSize is set. SVG output
+	// is never flagged.
+	MinModulePixels  int
+	StrictModuleSize bool
+	// FolderLevels lists the columns whose values nest the images into
+	// folders, outermost first (e.g. "KECAMATAN", "RW"). Nil means
+	// DefaultFolderLevels; an empty, non-nil slice puts every image in
+	// the output root.
+	FolderLevels []string
+	// FolderPlaceholder names the folder, at any of FolderLevels, of rows
+	// whose cell for that level is empty, e.g. "Unknown". Empty keeps the
+	// column name ("Kecamatan", "Kelurahan"). The KECAMATAN and
+	// KELURAHAN filename fields use it too.
+	FolderPlaceholder string
+	// FolderLayout is LayoutColumns (default), LayoutVersion or
+	// LayoutVersionColumns. Version folders are named like "V03-29x29"
+	// after the QR version and its module count.
+	FolderLayout string
+	// FilenameTemplate names each image, e.g. "{name}_{nik}". Fields are
+	// the column keys; the extension is added per Format. Empty means
+	// DefaultFilenameTemplate.
+	FilenameTemplate string
+	// GradientColor, when set, blends the dark modules from FgColor to
+	// this hex color along GradientDirection (GradientVertical by default,
+	// GradientHorizontal or GradientDiagonal). Finder patterns stay
+	// FgColor, and both ends must contrast with BgColor.
+	GradientColor     string
+	GradientDirection string
+	// EyeColor, when set, draws the three finder patterns ("eyes") in this
+	// hex color instead of FgColor; EyeBorderColor overrides it for their
+	// outer 7x7 frame. Both must contrast with BgColor.
+	EyeColor       string
+	EyeBorderColor string
+	// ContentPrefix and ContentSuffix wrap every trimmed QR value before
+	// it is encoded, e.g. "https://portal.example/verify?id=" around a
+	// bare ID. Empty cells are left empty.
+	ContentPrefix string
+	ContentSuffix string
+	// ContentFallback is encoded instead when a row's QR cell is empty: a
+	// template of column key fields like the file name's, e.g. "{nik}",
+	// filled with the row's values as they are. Prefix and suffix still
+	// apply. Empty keeps empty cells empty.
+	ContentFallback string
+	// MaxContentLength rejects rows whose content, including prefix and
+	// suffix, is longer in bytes. Zero means DefaultMaxContentLength.
+	MaxContentLength int
+	// ContentMode is ContentAuto (default), ContentNumeric,
+	// ContentAlphanumeric or ContentByte. Rows whose content doesn't fit
+	// the mode are reported invalid.
+	ContentMode string
+	// ModuleStyle is StyleSquare (default), StyleRounded or StyleDots.
+	// Finder patterns are drawn square in every style.
+	ModuleStyle string
+	// Supersample renders each image this many times larger
+	// (1..MaxSupersample) and scales it down with a Catmull-Rom filter,
+	// smoothing the curves of rounded and dot modules. Zero means
+	// DefaultSupersample, which draws at the final size.
+	Supersample int
+	// Label names a column (header or column key, e.g. "NAMA LENGKAP")
+	// whose value is printed under each code; the canvas grows to fit it
+	// and long values are cut with an ellipsis. Empty means no caption.
+	Label string
+	// ShowContent prints each code's QR content in a small monospace font
+	// under the code (and under the label, if any), for reading it out
+	// when scanning fails. Long values wrap onto at most three lines and
+	// end in an ellipsis. The code itself keeps its size.
+	ShowContent bool
+	// Border is the quiet zone around the code, in modules. Nil means
+	// DefaultBorder; zero renders the code edge to edge.
+	Border *int
+	// Padding is extra margin around the code and its quiet zone, in
+	// pixels and in the background color, for fitting codes to a fixed
+	// frame without changing the quiet zone scanners rely on. Labels
+	// span the padded width. Zero adds none.
+	Padding int
+	// MaxRows rejects files with more data rows before anything is
+	// generated. Zero means DefaultMaxRows; negative means no limit.
+	MaxRows int
+	// MaxDimension rejects images whose width or height would exceed it.
+	// Zero means DefaultMaxDimension.
+	MaxDimension int
+	// MaxImageMB rejects codes whose raster image would need more memory,
+	// counting 4 bytes per pixel. It guards against workers exhausting
+	// memory at large scales. Zero means DefaultMaxImageMB.
+	MaxImageMB int
+	// MaxOutputMB stops a run once the images it wrote add up to more,
+	// removing them again, so a large job can't fill shared storage.
+	// Generate then returns an *OutputLimitError. The zips need about as
+	// much space again. Zero means no limit.
+	MaxOutputMB int
+	// NIKLength and KKLength are the required digit counts after
+	// cleaning. Zero means DefaultIDLength.
+	NIKLength int
+	KKLength  int
+	// StrictNIK also checks the structure of each NIK: a known province
+	// code, non-zero regency and district codes and a possible birth
+	// date. Leave it off for datasets with synthetic IDs. It needs the
+	// default 16-digit NIKLength.
+	StrictNIK bool
+	// Workers is the number of rows rendered concurrently (1..MaxWorkers).
+	// Zero means runtime.NumCPU().
+	Workers int
+	// ZipMode is ZipSingle (default), ZipPerKecamatan, which zips each
+	// top-level folder (the first of FolderLevels, or the QR version with
+	// a version FolderLayout) separately, or ZipNone.
+	ZipMode string
+	// SkipZip leaves the images in OutputFolder without archiving them,
+	// for callers reading the folder directly. It is the same as ZipMode
+	// ZipNone and overrides any other ZipMode.
+	SkipZip bool
+	// ZipCompression is ZipDeflate (default) or ZipStore, applied to every
+	// file in the archives.
+	ZipCompression string
+	// ZipName names the archive, e.g. "batch-2024-06.zip"; it is
+	// sanitized and ".zip" is added when missing. In ZipPerKecamatan mode
+	// it prefixes each archive instead. Empty means the output folder name.
+	ZipName string
+	// MaxZipBytes splits each archive into parts of at most this size,
+	// "<name>.part1.zip", "<name>.part2.zip" and so on, for mail systems
+	// and download managers that balk at large files. Files are never
+	// split across parts, so a single file larger than the limit gets a
+	// part of its own. Zero means one archive. ZipStream ignores it.
+	MaxZipBytes int64
+	// DedupContent skips rows whose QR content repeats an earlier row,
+	// counting them in Result.Duplicates as well as Skipped.
+	DedupContent bool
+	// Overwrite regenerates images that already exist in the output
+	// folder instead of skipping them, counting them as generated. It
+	// only concerns files left by earlier runs: with DedupContent, rows
+	// repeating content in this run are still skipped.
+	Overwrite bool
+	// Manifest writes manifest.csv into the output root listing every row
+	// with its generated file and status. In ZipPerKecamatan mode it stays
+	// in the output folder rather than any of the per-kecamatan archives.
+	Manifest bool
+	// Cleanup removes the uncompressed output folder once the archives
+	// have been written successfully, keeping only the zips.
+	Cleanup bool
+	// PDF additionally writes every generated image, captioned with the
+	// name, into <output>.pdf next to the zip for printing.
+	PDF bool
+	// PDFColumns is the number of codes per row in the PDF
+	// (1..MaxPDFColumns). Zero means DefaultPDFColumns.
+	PDFColumns int
+	// Verify decodes every rendered code and fails the row unless it reads
+	// back as the original content. It roughly doubles the CPU per code.
+	Verify bool
+	// PNGMetadata adds text chunks to PNG images recording the creation
+	// time, the source spreadsheet, the request ID and the encoded
+	// content, so a printed code can be traced back to its batch. Other
+	// formats are unaffected.
+	PNGMetadata bool
+	// WriteRetries is how many times a row is retried after a transient
+	// filesystem error (EAGAIN, EBUSY, EINTR, or its folder vanishing),
+	// waiting longer each time. Zero disables retries; invalid rows and
+	// errors such as EACCES or ENOSPC are never retried.
+	WriteRetries int
+	// DirMode and FileMode are the permissions of the folders and files a
+	// run creates: the output folder and its subfolders, the images,
+	// manifest.csv, errors.txt, the zips and the PDF. When set they are
+	// applied as given, regardless of the process umask. Zero keeps
+	// DefaultDirMode for folders and 0666 less the umask for files.
+	DirMode  os.FileMode
+	FileMode os.FileMode
+	// ValidateOnly runs the row checks without writing any images or
+	// archives. Rows that pass are reported with status "valid".
+	ValidateOnly bool
+	// FailFast stops the run at the first invalid or failed row, returning
+	// a *RowError instead of a Result. Rows already being rendered finish,
+	// so their images stay in OutputFolder, but no archive, manifest or
+	// PDF is written.
+	FailFast bool
+	// FileTree lists the output folder's files in Result.Tree, for
+	// previewing the generated structure. Off by default, as the list
+	// grows with the job.
+	FileTree bool
+	// RequestID tags the log lines of this run so they can be matched
+	// to the request that started it.
+	RequestID string
+	// Lang is the language of the row messages and warnings in the
+	// Result and of the errors, such as invalid options, LangEnglish or
+	// LangIndonesian. Empty means English.
+	Lang string
+	// OnProgress, if set, is called after each row finishes with the
+	// running totals. Calls are serialized; keep the callback cheap.
+	OnProgress func(Progress)
+	// OnRow, if set, is called with each row's outcome as it finishes, in
+	// completion order. Calls are serialized like OnProgress.
+	OnRow func(RowResult)
+
+	logo  image.Image  // LogoPath decoded once per run by RunGenerate
+	tally *outputTally // images written by this run, set by Generate
+}
+
+func (o Options) format() string {
+	if o.Format == "" {
+		return FormatPNG
+	}
+	return strings.ToLower(o.Format)
+}
+
+// extension is the file extension (without dot) for the output format.
+func (o Options) extension() string {
+	if o.format() == FormatJPEG {
+		return "jpg"
+	}
+	return o.format()
+}
+
+func (o Options) jpegQuality() int {
+	if o.JPEGQuality == 0 {
+		return DefaultJPEGQuality
+	}
+	return o.JPEGQuality
+}
+
+// warnings lists non-fatal concerns about the options, reported in the
+// Result of every run.
+func (o Options) warnings() []string {
+	var warnings []string
+	if o.format() == FormatJPEG && o.jpegQuality() < MinSafeJPEGQuality {
+		warnings = append(warnings, o.text("jpeg_quality_low", o.jpegQuality(), MinSafeJPEGQuality))
+	}
+	if msg := o.smallModules(); msg != "" && !o.StrictModuleSize {
+		warnings = append(warnings, msg)
+	}
+	if fg, _, err := o.colors(); err == nil && o.TransparentBg && relativeLuminance(fg) > 0.5 {
+		warnings = append(warnings, o.text("light_foreground", hexColor(fg)))
+	}
+	return warnings
+}
+
+func (o Options) delimiter() rune {
+	switch o.Delimiter {
+	case "":
+		return 0
+	case "tab", `\t`:
+		return '\t'
+	}
+	r, _ := utf8.DecodeRuneInString(o.Delimiter)
+	return r
+}
+
+func (o Options) minModulePixels() int {
+	if o.MinModulePixels <= 0 {
+		return DefaultMinModulePixels
+	}
+	return o.MinModulePixels
+}
+
+// smallModules describes modules too small to scan reliably, or returns
+// "" when they are large enough.
+func (o Options) smallModules() string {
+	if o.format() == FormatSVG || o.scale() >= o.minModulePixels() {
+		return ""
+	}
+	return o.text("small_modules", o.scale(), o.minModulePixels())
+}
+
+func (o Options) scale() int {
+	if o.Scale == 0 {
+		return DefaultScale
+	}
+	return o.Scale
+}
+
+func (o Options) folderLevels() []string {
+	if o.FolderLevels == nil {
+		return DefaultFolderLevels
+	}
+	return o.FolderLevels
+}
+
+func (o Options) folderLayout() string {
+	if o.FolderLayout == "" {
+		return LayoutColumns
+	}
+	return strings.ToLower(o.FolderLayout)
+}
+
+func (o Options) filenameTemplate() string {
+	if o.FilenameTemplate == "" {
+		return DefaultFilenameTemplate
+	}
+	return o.FilenameTemplate
+}
+
+func (o Options) contentMode() string {
+	if o.ContentMode == "" {
+		return ContentAuto
+	}
+	return strings.ToLower(o.ContentMode)
+}
+
+func (o Options) moduleStyle() string {
+	if o.ModuleStyle == "" {
+		return StyleSquare
+	}
+	return strings.ToLower(o.ModuleStyle)
+}
+
+func (o Options) supersample() int {
+	if o.Supersample == 0 {
+		return DefaultSupersample
+	}
+	return o.Supersample
+}
+
+func (o Options) border() int {
+	if o.Border == nil {
+		return DefaultBorder
+	}
+	return *o.Border
+}
+
+func (o Options) maxRows() int {
+	if o.MaxRows == 0 {
+		return DefaultMaxRows
+	}
+	return o.MaxRows
+}
+
+func (o Options) maxContentLength() int {
+	if o.MaxContentLength <= 0 {
+		return DefaultMaxContentLength
+	}
+	return o.MaxContentLength
+}
+
+func (o Options) maxDimension() int {
+	if o.MaxDimension <= 0 {
+		return DefaultMaxDimension
+	}
+	return o.MaxDimension
+}
+
+func (o Options) maxImageMB() int {
+	if o.MaxImageMB <= 0 {
+		return DefaultMaxImageMB
+	}
+	return o.MaxImageMB
+}
+
+// zipBase is the archive name for outputFolder without ".zip".
+func (o Options) zipBase(outputFolder string) string {
+	if o.ZipName == "" {
+		return filepath.Base(outputFolder)
+	}
+	name := SanitizeFilename(o.ZipName)
+	if strings.EqualFold(filepath.Ext(name), ".zip") {
+		name = name[:len(name)-len(".zip")]
+	}
+	return name
+}
+
+// pngLevel is the encoder level for PNGCompression.
+func (o Options) pngLevel() png.CompressionLevel {
+	switch strings.ToLower(o.PNGCompression) {
+	case PNGDefault:
+		return png.DefaultCompression
+	case PNGSpeed:
+		return png.BestSpeed
+	case PNGNone:
+		return png.NoCompression
+	}
+	return png.BestCompression
+}
+
+// zipMethod is the archive entry method for ZipCompression.
+func (o Options) zipMethod() uint16 {
+	if strings.ToLower(o.ZipCompression) == ZipStore {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+func (o Options) zipMode() string {
+	if o.SkipZip {
+		return ZipNone
+	}
+	if o.ZipMode == "" {
+		return ZipSingle
+	}
+	return strings.ToLower(o.ZipMode)
+}
+
+func (o Options) nikLength() int {
+	if o.NIKLength == 0 {
+		return DefaultIDLength
+	}
+	return o.NIKLength
+}
+
+func (o Options) kkLength() int {
+	if o.KKLength == 0 {
+		return DefaultIDLength
+	}
+	return o.KKLength
+}
+
+// logger returns the default logger, tagged with the request ID if set.
+func (o Options) logger() *slog.Logger {
+	if o.RequestID == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("request_id", o.RequestID)
+}
+
+func (o Options) pdfColumns() int {
+	if o.PDFColumns == 0 {
+		return DefaultPDFColumns
+	}
+	return o.PDFColumns
+}
+
+func (o Options) workers() int {
+	if o.Workers == 0 {
+		return min(runtime.NumCPU(), MaxWorkers)
+	}
+	return o.Workers
+}
+
+// contentColumns resolves ContentColumns to the header names rows are
+// keyed by, or nil for the single ColQR column.
+func (o Options) contentColumns() []string {
+	if len(o.ContentColumns) == 0 {
+		return nil
+	}
+	columns := make([]string, len(o.ContentColumns))
+	for i, column := range o.ContentColumns {
+		column = strings.TrimSpace(column)
+		if canonical, ok := canonicalColumns[column]; ok {
+			column = canonical
+		}
+		columns[i] = column
+	}
+	return columns
+}
+
+func (o Options) validate() error {
+	if o.Lang != "" && !SupportedLang(o.Lang) {
+		return errors.New(o.text("lang_unsupported", o.Lang))
+	}
+	if err := o.validateCard(); err != nil {
+		return err
+	}
+	if o.WiFi && (o.card() != "" || len(o.ContentColumns) > 0) {
+		return errors.New(o.text("wifi_combined"))
+	}
+	switch o.format() {
+	case FormatPNG, FormatSVG, FormatJPEG, FormatWebP:
+	default:
+		return errors.New(o.text("format_unsupported", o.Format))
+	}
+	switch normalizeCharset(o.Charset) {
+	case CharsetAuto, CharsetUTF8, CharsetWindows1252, CharsetLatin1:
+	default:
+		return errors.New(o.text("charset_unsupported", o.Charset))
+	}
+	if o.Delimiter != "" && o.delimiter() != '\t' && utf8.RuneCountInString(o.Delimiter) != 1 {
+		return errors.New(o.text("delimiter_invalid", o.Delimiter))
+	}
+	if o.TransparentBg && o.format() == FormatJPEG {
+		return errors.New(o.text("jpeg_transparent"))
+	}
+	if q := o.jpegQuality(); q < 1 || q > 100 {
+		return errors.New(o.text("jpeg_quality_range", q))
+	}
+	if o.MaxOutputMB < 0 {
+		return errors.New(o.text("output_limit_negative", o.MaxOutputMB))
+	}
+	if scale := o.scale(); scale < MinScale || scale > MaxScale {
+		return errors.New(o.text("scale_range", MinScale, MaxScale, scale))
+	}
+	if msg := o.smallModules(); msg != "" && o.StrictModuleSize {
+		return errors.New(msg)
+	}
+	if err := o.validateTemplate("filename_template", o.filenameTemplate()); err != nil {
+		return err
+	}
+	if o.ContentFallback != "" {
+		if err := o.validateTemplate("content_fallback", o.ContentFallback); err != nil {
+			return err
+		}
+	}
+	switch o.contentMode() {
+	case ContentAuto, ContentNumeric, ContentAlphanumeric, ContentByte:
+	default:
+		return errors.New(o.text("content_mode_unsupported", o.ContentMode))
+	}
+	switch o.moduleStyle() {
+	case StyleSquare, StyleRounded, StyleDots:
+	default:
+		return errors.New(o.text("style_unsupported", o.ModuleStyle))
+	}
+	if n := o.supersample(); n < 1 || n > MaxSupersample {
+		return errors.New(o.text("supersample_range", MaxSupersample, n))
+	}
+	if o.border() < 0 {
+		return errors.New(o.text("border_negative", o.border()))
+	}
+	if o.Padding < 0 {
+		return errors.New(o.text("padding_negative", o.Padding))
+	}
+	switch o.zipMode() {
+	case ZipSingle, ZipPerKecamatan, ZipNone:
+	default:
+		return errors.New(o.text("zip_mode_unsupported", o.ZipMode))
+	}
+	switch strings.ToLower(o.ZipCompression) {
+	case "", ZipDeflate, ZipStore:
+	default:
+		return errors.New(o.text("zip_compression_unsupported", o.ZipCompression))
+	}
+	switch strings.ToLower(o.PNGCompression) {
+	case "", PNGBest, PNGDefault, PNGSpeed, PNGNone:
+	default:
+		return errors.New(o.text("png_compression_unsupported", o.PNGCompression))
+	}
+	if o.MaxZipBytes < 0 {
+		return errors.New(o.text("zip_part_negative", o.MaxZipBytes))
+	}
+	if o.ZipName != "" && strings.Trim(o.zipBase(""), "._-") == "" {
+		return errors.New(o.text("zip_name_invalid", o.ZipName))
+	}
+	if o.Cleanup && o.zipMode() == ZipNone {
+		return errors.New(o.text("cleanup_without_zip", ZipNone))
+	}
+	switch o.folderLayout() {
+	case LayoutColumns, LayoutVersion, LayoutVersionColumns:
+	default:
+		return errors.New(o.text("folder_layout_unsupported", o.FolderLayout))
+	}
+	if o.zipMode() == ZipPerKecamatan && o.folderLayout() == LayoutColumns && len(o.folderLevels()) == 0 {
+		return errors.New(o.text("zip_per_kecamatan_levels", ZipPerKecamatan))
+	}
+	for _, level := range o.folderLevels() {
+		if strings.TrimSpace(level) == "" {
+			return errors.New(o.text("folder_level_empty"))
+		}
+	}
+	if o.Sheet != "" && len(o.Sheets) > 0 {
+		return errors.New(o.text("sheet_and_sheets"))
+	}
+	if o.FolderPlaceholder != "" && SanitizeFolder(o.FolderPlaceholder) == "" {
+		return errors.New(o.text("folder_placeholder_invalid", o.FolderPlaceholder))
+	}
+	suffixes := make(map[string]string)
+	for _, column := range o.contentColumns() {
+		suffix := SanitizeFilename(column)
+		if suffix == "" {
+			return errors.New(o.text("content_column_empty"))
+		}
+		if prev, ok := suffixes[suffix]; ok {
+			return errors.New(o.text("content_column_clash", prev, column))
+		}
+		suffixes[suffix] = column
+	}
+	if o.WriteRetries < 0 {
+		return errors.New(o.text("write_retries_negative", o.WriteRetries))
+	}
+	if o.DirMode&^os.ModePerm != 0 || o.FileMode&^os.ModePerm != 0 {
+		return errors.New(o.text("file_modes_invalid", uint32(o.DirMode), uint32(o.FileMode)))
+	}
+	if o.DirMode != 0 && o.DirMode&0700 != 0700 {
+		return errors.New(o.text("dir_mode_owner", uint32(o.DirMode)))
+	}
+	if o.NIKLength < 0 || o.KKLength < 0 {
+		return errors.New(o.text("id_length_negative"))
+	}
+	if o.StrictNIK && o.nikLength() != DefaultIDLength {
+		return errors.New(o.text("strict_nik_length", DefaultIDLength, o.nikLength()))
+	}
+	if n := o.pdfColumns(); n < 1 || n > MaxPDFColumns {
+		return errors.New(o.text("pdf_columns_range", MaxPDFColumns, n))
+	}
+	if workers := o.workers(); workers < 1 || workers > MaxWorkers {
+		return errors.New(o.text("workers_range", MaxWorkers, workers))
+	}
+	if _, _, err := o.colors(); err != nil {
+		return err
+	}
+	if _, _, err := o.gradient(); err != nil {
+		return err
+	}
+	if _, _, err := o.eyeColors(); err != nil {
+		return err
+	}
+	if err := o.checkStrictISO(); err != nil {
+		return err
+	}
+	level, err := o.recoveryLevel()
+	if err != nil {
+		return err
+	}
+	if o.LogoPath != "" && level < qrcode.High {
+		return errors.New(o.text("logo_ecc", o.ECC))
+	}
+	return o.validateColumnMap()
+}
+
+// gradient returns the gradient direction and end color, or an empty
+// direction when the modules are a single color.
+func (o Options) gradient() (string, color.RGBA, error) {
+	if o.GradientColor == "" {
+		return "", color.RGBA{}, nil
+	}
+	end, err := ParseHexColor(o.GradientColor)
+	if err != nil {
+		return "", end, errors.New(o.text("color_invalid", o.text("gradient_color"), o.GradientColor))
+	}
+	_, bg, err := o.colors()
+	if err != nil {
+		return "", end, err
+	}
+	if ratio := ContrastRatio(end, bg); ratio < MinContrastRatio && !o.TransparentBg {
+		return "", end, errors.New(o.text("contrast_low", hexColor(end), hexColor(bg), ratio, MinContrastRatio))
+	}
+
+	direction := strings.ToLower(o.GradientDirection)
+	switch direction {
+	case "":
+		direction = GradientVertical
+	case GradientVertical, GradientHorizontal, GradientDiagonal:
+	default:
+		return "", end, errors.New(o.text("gradient_direction_unsupported", o.GradientDirection))
+	}
+	return direction, end, nil
+}
+
+// eyeColors parses EyeColor and EyeBorderColor. A zero color means the
+// part keeps the module color.
+func (o Options) eyeColors() (eye, frame color.RGBA, err error) {
+	_, bg, err := o.colors()
+	if err != nil {
+		return eye, frame, err
+	}
+	parse := func(key, value string) (color.RGBA, error) {
+		if value == "" {
+			return color.RGBA{}, nil
+		}
+		c, err := ParseHexColor(value)
+		if err != nil {
+			return c, errors.New(o.text("color_invalid", o.text(key), value))
+		}
+		if ratio := ContrastRatio(c, bg); ratio < MinContrastRatio && !o.TransparentBg {
+			return c, errors.New(o.text("contrast_low", hexColor(c), hexColor(bg), ratio, MinContrastRatio))
+		}
+		return c, nil
+	}
+	if eye, err = parse("eye_color", o.EyeColor); err != nil {
+		return eye, frame, err
+	}
+	frame, err = parse("eye_border_color", o.EyeBorderColor)
+	return eye, frame, err
+}
+
+func (o Options) recoveryLevel() (qrcode.RecoveryLevel, error) {
+	switch strings.ToLower(o.ECC) {
+	case "", "highest":
+		return qrcode.Highest, nil
+	case "high":
+		return qrcode.High, nil
+	case "medium":
+		return qrcode.Medium, nil
+	case "low":
+		return qrcode.Low, nil
+	}
+	return 0, errors.New(o.text("ecc_unsupported", o.ECC))
+}
+
+// logoImage returns the decoded logo, or nil when no logo is configured.
+func (o Options) logoImage() (image.Image, error) {
+	if o.logo != nil || o.LogoPath == "" {
+		return o.logo, nil
+	}
+	return loadLogo(o.LogoPath)
+}
+
+// checkStrictISO rejects any option that StrictISO rules out.
+func (o Options) checkStrictISO() error {
+	if !o.StrictISO {
+		return nil
+	}
+	fg, bg, _ := o.colors()
+	switch {
+	case o.border() != DefaultBorder:
+		return errors.New(o.text("strict_iso_border", DefaultBorder, o.border()))
+	case o.moduleStyle() != StyleSquare:
+		return errors.New(o.text("strict_iso_style", o.ModuleStyle))
+	case o.TransparentBg || fg != defaultFgColor || bg != defaultBgColor:
+		return errors.New(o.text("strict_iso_colors"))
+	case o.LogoPath != "":
+		return errors.New(o.text("strict_iso_logo"))
+	case o.GradientColor != "" || o.GradientDirection != "":
+		return errors.New(o.text("strict_iso_gradient"))
+	case o.EyeColor != "" || o.EyeBorderColor != "":
+		return errors.New(o.text("strict_iso_eye"))
+	}
+	return nil
+}
+
+// colors resolves FgColor/BgColor and rejects combinations whose contrast
+// is too low to scan reliably.
+func (o Options) colors() (fg, bg color.RGBA, err error) {
+	fg, bg = defaultFgColor, defaultBgColor
+	if o.FgColor != "" {
+		if fg, err = ParseHexColor(o.FgColor); err != nil {
+			return fg, bg, errors.New(o.text("color_invalid", o.text("fg_color"), o.FgColor))
+		}
+	}
+	if o.BgColor != "" {
+		if bg, err = ParseHexColor(o.BgColor); err != nil {
+			return fg, bg, errors.New(o.text("color_invalid", o.text("bg_color"), o.BgColor))
+		}
+	}
+	if o.TransparentBg {
+		return fg, color.RGBA{}, nil
+	}
+	if ratio := ContrastRatio(fg, bg); ratio < MinContrastRatio {
+		return fg, bg, errors.New(o.text("contrast_low", hexColor(fg), hexColor(bg), ratio, MinContrastRatio))
+	}
+	return fg, bg, nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// errOutputLimit is the cancel cause of a run stopped by MaxOutputMB.
+var errOutputLimit = errors.New("output size limit exceeded")
+
+// OutputLimitError is returned when the images of a run grow past
+// Options.MaxOutputMB. The run is stopped and the images it wrote are
+// removed; Result holds the rows finished before that, with the bytes
+// written in BytesWritten.
+type OutputLimitError struct {
+	LimitMB int
+	Result  *Result
+	lang    string
+}
+
+func (e *OutputLimitError) Error() string {
+	return messages.Text(e.lang, "output_limit", e.LimitMB, float64(e.Result.BytesWritten)/(1<<20), e.Result.Generated)
+}
+
+// outputTally counts what a run writes, keeping the paths so an aborted
+// run can remove its own images without touching older files in the
+// folder.
+type outputTally struct {
+	mu    sync.Mutex
+	bytes int64
+	paths []string
+}
+
+// add records a written file and returns the running total.
+func (t *outputTally) add(path string) int64 {
+	info, err := os.Stat(path)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		t.bytes += info.Size()
+	}
+	t.paths = append(t.paths, path)
+	return t.bytes
+}
+
+func (t *outputTally) total() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bytes
+}
+
+// remove deletes the tallied files, then the folders under (and
+// including) outputFolder left empty by that, deepest first.
+func (t *outputTally) remove(outputFolder string, opts Options) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, path := range t.paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			opts.logger().Warn("cleanup failed", "path", path, "error", err)
+		}
+	}
+	var dirs []string
+	filepath.WalkDir(outputFolder, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		os.Remove(dir) // fails, as wanted, on folders still holding files
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// PDF grid settings: the number of QR codes per row on an A4 page.
+const (
+	DefaultPDFColumns = 3
+	MaxPDFColumns     = 8
+)
+
+// A4 page layout, in millimeters.
+const (
+	pdfPageWidth     = 210.0
+	pdfPageHeight    = 297.0
+	pdfMargin        = 10.0
+	pdfPadding       = 3.0
+	pdfCaptionHeight = 6.0
+)
+
+// savePDF lays out the image of every generated row on A4 pages, in file
+// order, with the person's name underneath. Plain PNG and JPEG images are
+// taken from outputFolder; other formats, and images with a caption band
+// or padding, are rendered again as bare PNG codes.
+func savePDF(pdfPath, outputFolder, filePath string, result *Result, opts Options) error {
+	src, err := openRows(filePath, opts)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	imageType := ""
+	switch opts.format() {
+	case FormatPNG:
+		imageType = "PNG"
+	case FormatJPEG:
+		imageType = "JPG"
+	}
+	// the PDF has its own captions and spacing, and the cells are square
+	if opts.Label != "" || opts.ShowContent || opts.Padding != 0 {
+		imageType = ""
+	}
+	pngOpts := opts
+	pngOpts.Format = FormatPNG
+	pngOpts.Label = ""
+	pngOpts.ShowContent = false
+	pngOpts.Padding = 0
+
+	columns := opts.pdfColumns()
+	cellWidth := (pdfPageWidth - 2*pdfMargin) / float64(columns)
+	imageSize := cellWidth - 2*pdfPadding
+	cellHeight := cellWidth + pdfCaptionHeight
+	perPage := columns * int((pdfPageHeight-2*pdfMargin)/cellHeight)
+
+	doc := fpdf.New("P", "mm", "A4", "")
+	doc.SetAutoPageBreak(false, 0)
+	doc.SetFont("Helvetica", "", 8)
+	tr := doc.UnicodeTranslatorFromDescriptor("")
+
+	placed := 0
+	for i := 0; i < len(result.Rows); i++ {
+		row, err := src.Next()
+		if err != nil {
+			return err
+		}
+		rr := result.Rows[i]
+		plan, _, _ := planRow(row, opts)
+		if plan == nil || !(rr.Status == "ok" || rr.Status == "skip" && rr.Message == plan.Filename) {
+			continue
+		}
+
+		var img io.Reader
+		options := fpdf.ImageOptions{ImageType: imageType}
+		if imageType != "" {
+			data, err := os.ReadFile(filepath.Join(outputFolder, plan.Dir, plan.Filename))
+			if err != nil {
+				return err
+			}
+			img = bytes.NewReader(data)
+		} else {
+			var buf bytes.Buffer
+			if err := writeQR(&buf, plan, pngOpts); err != nil {
+				return err
+			}
+			img = &buf
+			options.ImageType = "PNG"
+		}
+
+		if placed%perPage == 0 {
+			doc.AddPage()
+		}
+		slot := placed % perPage
+		x := pdfMargin + float64(slot%columns)*cellWidth
+		y := pdfMargin + float64(slot/columns)*cellHeight
+
+		name := "qr" + strconv.Itoa(i)
+		doc.RegisterImageOptionsReader(name, options, img)
+		doc.ImageOptions(name, x+pdfPadding, y+pdfPadding, imageSize, imageSize, false, options, 0, "")
+		doc.SetXY(x, y+pdfPadding+imageSize)
+		doc.CellFormat(cellWidth, pdfCaptionHeight-pdfPadding, tr(row.Values["NAMA LENGKAP"]), "", 0, "C", false, 0, "")
+		placed++
+	}
+
+	if placed == 0 {
+		doc.AddPage()
+	}
+	if err := doc.OutputFileAndClose(pdfPath); err != nil {
+		return fmt.Errorf("failed to write pdf: %v", err)
+	}
+	return opts.chmodFile(pdfPath)
+}
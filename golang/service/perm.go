@@ -0,0 +1,62 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultDirMode is the permission of created folders when
+// Options.DirMode is unset.
+const DefaultDirMode os.FileMode = 0755
+
+// mkdirAll creates dir and any missing parents. With Options.DirMode set,
+// dir and every folder between it and base get exactly that mode, so
+// umask can't take group write away from them.
+func (o Options) mkdirAll(base, dir string) error {
+	mode := o.DirMode
+	if mode == 0 {
+		return os.MkdirAll(dir, DefaultDirMode)
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(base, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return os.Chmod(dir, mode)
+	}
+	for p := dir; ; p = filepath.Dir(p) {
+		if err := os.Chmod(p, mode); err != nil {
+			return err
+		}
+		if p == filepath.Clean(base) {
+			return nil
+		}
+	}
+}
+
+// createFile creates or truncates path for writing, with Options.FileMode
+// when set.
+func (o Options) createFile(path string) (*os.File, error) {
+	if o.FileMode == 0 {
+		return os.Create(path)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, o.FileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(o.FileMode); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// chmodFile applies Options.FileMode to a file written by another
+// library, such as the PDF.
+func (o Options) chmodFile(path string) error {
+	if o.FileMode == 0 {
+		return nil
+	}
+	return os.Chmod(path, o.FileMode)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+)
+
+// pngSoftware is written as the PNG "Software" text keyword.
+const pngSoftware = "generate-qr"
+
+// pngText is one text chunk: a keyword from the PNG specification's
+// predefined list and its value.
+type pngText struct {
+	keyword, value string
+}
+
+// pngMetadata is what Options.PNGMetadata writes into each image: when
+// and by what it was made, the spreadsheet it came from and the content
+// it encodes.
+func pngMetadata(plan *rowPlan, opts Options, now time.Time) []pngText {
+	texts := []pngText{
+		{"Software", pngSoftware},
+		{"Creation Time", now.UTC().Format(time.RFC1123)},
+	}
+	if source := opts.SourceName; source != "" {
+		texts = append(texts, pngText{"Source", source})
+	} else if opts.FilePath != "" {
+		texts = append(texts, pngText{"Source", filepath.Base(opts.FilePath)})
+	}
+	if opts.RequestID != "" {
+		texts = append(texts, pngText{"Comment", "request " + opts.RequestID})
+	}
+	return append(texts, pngText{"Description", plan.Content})
+}
+
+// writePNGText copies the encoded PNG data to w with texts added as
+// chunks right after IHDR. Values outside Latin-1, which tEXt is limited
+// to, are written as uncompressed UTF-8 iTXt chunks instead.
+func writePNGText(w io.Writer, data []byte, texts []pngText) error {
+	// 8-byte signature, then IHDR: length, type, 13 bytes of data, CRC
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || !bytes.Equal(data[12:16], []byte("IHDR")) {
+		return errors.New("PNG metadata: not an encoded PNG")
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:ihdrEnd])
+	for _, t := range texts {
+		if latin1, ok := toLatin1(t.value); ok {
+			writePNGChunk(&buf, "tEXt", []byte(t.keyword+"\x00"+latin1))
+		} else {
+			// compression flag and method, empty language tag and
+			// translated keyword
+			writePNGChunk(&buf, "iTXt", []byte(t.keyword+"\x00\x00\x00\x00\x00"+t.value))
+		}
+	}
+	buf.Write(data[ihdrEnd:])
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writePNGChunk(buf *bytes.Buffer, kind string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	buf.Write(n[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	buf.WriteString(kind)
+	buf.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	buf.Write(n[:])
+}
+
+// toLatin1 converts s to ISO 8859-1 bytes, reporting false when it holds
+// a character Latin-1 doesn't have.
+func toLatin1(s string) (string, bool) {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF || r == utf8.RuneError {
+			return "", false
+		}
+		out = append(out, byte(r))
+	}
+	return string(out), true
+}
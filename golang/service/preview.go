@@ -0,0 +1,50 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// formatMIME are the media types of the output formats.
+var formatMIME = map[string]string{
+	FormatPNG:  "image/png",
+	FormatSVG:  "image/svg+xml",
+	FormatJPEG: "image/jpeg",
+	FormatWebP: "image/webp",
+}
+
+// PreviewQR writes content to w as a PNG, styled like GenerateQR would
+// style it with opts. The format, label and logo settings are ignored.
+func PreviewQR(w io.Writer, content string, opts Options) error {
+	opts.Format = FormatPNG
+	return writeSingleQR(w, content, opts)
+}
+
+// DataURI renders content in opts.Format and returns it as a base64
+// "data:" URI, ready to be used as an image source. The label and logo
+// settings are ignored.
+func DataURI(content string, opts Options) (string, error) {
+	var buf bytes.Buffer
+	if err := writeSingleQR(&buf, content, opts); err != nil {
+		return "", err
+	}
+	return "data:" + formatMIME[opts.format()] + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// writeSingleQR renders one code from content rather than a row.
+func writeSingleQR(w io.Writer, content string, opts Options) error {
+	opts.Label = ""
+	opts.LogoPath = ""
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if content == "" {
+		return errors.New("no QR content given")
+	}
+	if msg := checkContent(content, opts); msg != "" {
+		return errors.New(msg)
+	}
+	return writeQR(w, &rowPlan{Content: content}, opts)
+}
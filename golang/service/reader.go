@@ -0,0 +1,581 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/transform"
+)
+
+// rowReader yields the data rows of a spreadsheet one at a time, so large
+// files never have to be held in memory as a whole.
+type rowReader interface {
+	// Next returns the next data row, or io.EOF after the last one.
+	Next() (sourceRow, error)
+	// Warnings lists problems found in the header, such as repeated
+	// column names.
+	Warnings() []string
+	Close() error
+}
+
+// openRows opens filePath with the reader matching its extension. The
+// header is read and checked before it returns. Rows without any value
+// are dropped, and with several content columns each data row is
+// returned once per column.
+func openRows(filePath string, opts Options) (rowReader, error) {
+	sheet, err := openSheet(filePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	src := &nonEmptyRows{rowReader: sheet}
+	if content := opts.contentColumns(); content != nil {
+		return &contentRows{src: src, columns: content}, nil
+	}
+	return src, nil
+}
+
+func openSheet(filePath string, opts Options) (rowReader, error) {
+	content := opts.contentColumns()
+	// the card or WiFi columns stand in for the QR column
+	if opts.card() != "" {
+		content = opts.cardColumns()
+	} else if opts.WiFi {
+		content = []string{WiFiSSIDColumn}
+	}
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if (ext == ".xlsx" || ext == ".xls") && len(opts.Sheets) > 0 {
+		return openExcelSheets(filePath, opts.Columns, content, opts.Sheets)
+	} else if ext == ".xlsx" || ext == ".xls" {
+		return openExcel(filePath, opts.Columns, content, opts.Sheet)
+	} else if len(opts.Sheets) > 0 {
+		return nil, fmt.Errorf("reading several sheets needs an Excel file, got %s", ext)
+	} else if ext == ".ods" {
+		return readODS(filePath, opts.Columns, content, opts.Sheet)
+	} else if ext == ".csv" || ext == ".txt" {
+		return openCSV(filePath, opts.Columns, content, opts.delimiter(), normalizeCharset(opts.Charset), opts.Lang)
+	} else if ext == ".tsv" {
+		delimiter := opts.delimiter()
+		if delimiter == 0 {
+			delimiter = '\t'
+		}
+		return openCSV(filePath, opts.Columns, content, delimiter, normalizeCharset(opts.Charset), opts.Lang)
+	}
+	return nil, fmt.Errorf("unsupported file format: %s", ext)
+}
+
+// countRows reads the whole file once to count its data rows, not
+// counting the repeats for content columns.
+func countRows(filePath string, opts Options) (int, error) {
+	src, err := openSheet(filePath, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	n := 0
+	rows := &nonEmptyRows{rowReader: src}
+	for {
+		if _, err := rows.Next(); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// excelRows streams a worksheet through excelize's row iterator. Like
+// GetRows, it keeps empty rows between data rows but drops the trailing
+// ones.
+type excelRows struct {
+	f        *excelize.File
+	sheet    string
+	rows     *excelize.Rows
+	raw      *excelize.Rows // the same rows, unformatted
+	headers  []string
+	warnings []string
+	line     int      // sheet row of the last row returned
+	blank    int      // empty rows read ahead but not yet returned
+	held     []string // non-empty row waiting behind the blank ones
+}
+
+// openExcel opens the given sheet, or the first one when sheet is empty.
+func openExcel(filePath string, columns map[string]string, content []string, sheet string) (*excelRows, error) {
+	f, err := excelize.OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := startExcel(f, columns, content, sheet)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func startExcel(f *excelize.File, columns map[string]string, content []string, sheet string) (*excelRows, error) {
+	name, err := resolveSheet(f.GetSheetList(), sheet)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := f.Rows(name)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := f.Rows(name)
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	r := &excelRows{f: f, sheet: name, rows: rows, raw: raw, line: 1}
+	if err := r.readHeader(columns, content); err != nil {
+		r.closeRows()
+		return nil, err
+	}
+	return r, nil
+}
+
+// next advances both row iterators.
+func (r *excelRows) next() bool {
+	r.raw.Next()
+	return r.rows.Next()
+}
+
+func (r *excelRows) readHeader(columns map[string]string, content []string) error {
+	if !r.next() {
+		return fmt.Errorf("empty excel file")
+	}
+	header, err := r.rows.Columns()
+	if err != nil {
+		return err
+	}
+	if _, err := r.raw.Columns(); err != nil {
+		return err
+	}
+	if r.headers, r.warnings, err = headerKeys(header, columns, content); err != nil {
+		return err
+	}
+
+	// make sure there is at least one data row, as GetRows would
+	if err := r.fill(); err != nil {
+		return err
+	}
+	if r.held == nil {
+		return fmt.Errorf("empty excel file")
+	}
+	return nil
+}
+
+// fill reads ahead to the next non-empty row, counting the blank ones it
+// passes.
+func (r *excelRows) fill() error {
+	for r.next() {
+		cells, err := r.rows.Columns()
+		if err != nil {
+			return err
+		}
+		raw, err := r.raw.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			return err
+		}
+		if len(cells) > 0 {
+			r.held = fixNumbers(cells, raw)
+			return nil
+		}
+		r.blank++
+	}
+	r.blank = 0
+	return r.rows.Error()
+}
+
+func (r *excelRows) Next() (sourceRow, error) {
+	if r.blank == 0 && r.held == nil {
+		if err := r.fill(); err != nil {
+			return sourceRow{}, err
+		}
+		if r.held == nil {
+			return sourceRow{}, io.EOF
+		}
+	}
+
+	r.line++
+	if r.blank > 0 {
+		r.blank--
+		return sourceRow{Line: r.line, Values: map[string]string{}}, nil
+	}
+	cells := r.held
+	r.held = nil
+	return sourceRow{Line: r.line, Values: rowValues(r.headers, cells)}, nil
+}
+
+func (r *excelRows) Warnings() []string { return r.warnings }
+
+// scientific matches numbers excelize has formatted in E notation, which
+// it does for General cells with more than 15 digits.
+var scientific = regexp.MustCompile(`^-?\d(\.\d+)?E[+-]?\d+$`)
+
+// fixNumbers replaces the cells shown in E notation, such as a NIK typed
+// as a number ("3.20123456789E+15"), with the digits stored in the file,
+// taken from the same row read unformatted.
+func fixNumbers(cells, raw []string) []string {
+	for i, cell := range cells {
+		if scientific.MatchString(cell) && i < len(raw) && raw[i] != "" {
+			cells[i] = plainNumber(raw[i])
+		}
+	}
+	return cells
+}
+
+// plainNumber writes a stored number without E notation, so whole numbers
+// come out as their digits.
+func plainNumber(raw string) string {
+	f, _, err := big.ParseFloat(strings.TrimSpace(raw), 10, 256, big.ToNearestEven)
+	if err != nil {
+		return raw
+	}
+	if f.IsInt() {
+		return f.Text('f', 0)
+	}
+	return f.Text('f', -1)
+}
+
+func (r *excelRows) closeRows() {
+	r.rows.Close()
+	r.raw.Close()
+}
+
+func (r *excelRows) Close() error {
+	r.closeRows()
+	return r.f.Close()
+}
+
+// sheetsRows reads several worksheets of one workbook in turn, each
+// through its own excelRows with its own header.
+type sheetsRows struct {
+	f        *excelize.File
+	sheets   []*excelRows
+	current  int
+	warnings []string
+}
+
+// openExcelSheets opens the listed sheets, or all of them for "*", and
+// checks every header before any row is read.
+func openExcelSheets(filePath string, columns map[string]string, content []string, sheets []string) (*sheetsRows, error) {
+	f, err := excelize.OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	s := &sheetsRows{f: f}
+	names := sheets
+	if len(sheets) == 1 && strings.TrimSpace(sheets[0]) == "*" {
+		names = f.GetSheetList()
+	}
+	seen := make(map[string]bool)
+	for _, sheet := range names {
+		name, err := resolveSheet(f.GetSheetList(), sheet)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		r, err := startExcel(f, columns, content, name)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("sheet %s: %w", name, err)
+		}
+		s.sheets = append(s.sheets, r)
+		for _, w := range r.warnings {
+			s.warnings = append(s.warnings, fmt.Sprintf("sheet %s: %s", name, w))
+		}
+	}
+	return s, nil
+}
+
+func (s *sheetsRows) Next() (sourceRow, error) {
+	for s.current < len(s.sheets) {
+		r := s.sheets[s.current]
+		row, err := r.Next()
+		if err == io.EOF {
+			s.current++
+			continue
+		}
+		row.Sheet = r.sheet
+		return row, err
+	}
+	return sourceRow{}, io.EOF
+}
+
+func (s *sheetsRows) Warnings() []string { return s.warnings }
+
+func (s *sheetsRows) Close() error {
+	for _, r := range s.sheets {
+		r.closeRows()
+	}
+	return s.f.Close()
+}
+
+// resolveSheet picks a sheet by exact name or, failing that, by 1-based
+// position.
+func resolveSheet(sheets []string, sheet string) (string, error) {
+	if len(sheets) == 0 {
+		return "", fmt.Errorf("workbook has no sheets")
+	}
+	sheet = strings.TrimSpace(sheet)
+	if sheet == "" {
+		return sheets[0], nil
+	}
+	for _, name := range sheets {
+		if name == sheet {
+			return name, nil
+		}
+	}
+	if n, err := strconv.Atoi(sheet); err == nil && n >= 1 && n <= len(sheets) {
+		return sheets[n-1], nil
+	}
+	return "", fmt.Errorf("sheet %q not found, available sheets: %s", sheet, strings.Join(sheets, ", "))
+}
+
+// tableRows maps a sheet whose first row is the header onto sourceRows,
+// numbering lines as a spreadsheet would.
+func tableRows(rows [][]string, columns map[string]string, content []string) (*sliceRows, error) {
+	headers, warnings, err := headerKeys(rows[0], columns, content)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &sliceRows{warnings: warnings}
+	for n, row := range rows[1:] {
+		result.rows = append(result.rows, sourceRow{Line: n + 2, Values: rowValues(headers, row)})
+	}
+	return result, nil
+}
+
+// rowValues keys cells by their column; cells past the header are dropped.
+// Whitespace is normalized so "Bandung " and "Bandung" end up in the same
+// folder.
+func rowValues(headers, cells []string) map[string]string {
+	data := make(map[string]string)
+	for i, cell := range cells {
+		if i < len(headers) {
+			data[headers[i]] = normalizeSpace(cell)
+		}
+	}
+	return data
+}
+
+// normalizeSpace trims a cell and collapses every run of spaces, tabs and
+// non-breaking spaces into one space. Line breaks are kept, minus the
+// spaces around them, so multi-line QR content survives.
+func normalizeSpace(cell string) string {
+	lines := strings.Split(strings.ReplaceAll(cell, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// sliceRows serves rows that were already read into memory.
+type sliceRows struct {
+	rows     []sourceRow
+	warnings []string
+}
+
+func (s *sliceRows) Next() (sourceRow, error) {
+	if len(s.rows) == 0 {
+		return sourceRow{}, io.EOF
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+func (s *sliceRows) Warnings() []string { return s.warnings }
+
+func (s *sliceRows) Close() error { return nil }
+
+// nonEmptyRows drops the rows of a sheet whose cells are all empty, such
+// as stray blank rows in Excel, counting them in skipped.
+type nonEmptyRows struct {
+	rowReader
+	skipped int
+}
+
+func (r *nonEmptyRows) Next() (sourceRow, error) {
+	for {
+		row, err := r.rowReader.Next()
+		if err != nil || !emptyRow(row.Values) {
+			return row, err
+		}
+		r.skipped++
+	}
+}
+
+func emptyRow(values map[string]string) bool {
+	for _, v := range values {
+		if v != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// emptyRowsSkipped is how many empty rows src has dropped so far.
+func emptyRowsSkipped(src rowReader) int {
+	switch r := src.(type) {
+	case *nonEmptyRows:
+		return r.skipped
+	case *contentRows:
+		return emptyRowsSkipped(r.src)
+	}
+	return 0
+}
+
+// contentRows repeats every row of src once per content column, tagging
+// each copy with the column its QR content comes from.
+type contentRows struct {
+	src     rowReader
+	columns []string
+	row     sourceRow
+	next    int // index into columns of the next copy of row
+}
+
+func (c *contentRows) Next() (sourceRow, error) {
+	if c.next == 0 || c.next == len(c.columns) {
+		row, err := c.src.Next()
+		if err != nil {
+			return sourceRow{}, err
+		}
+		c.row, c.next = row, 0
+	}
+	row := c.row
+	row.Column = c.columns[c.next]
+	c.next++
+	return row, nil
+}
+
+func (c *contentRows) Warnings() []string { return c.src.Warnings() }
+
+func (c *contentRows) Close() error { return c.src.Close() }
+
+// csvRows streams a delimited text file record by record.
+type csvRows struct {
+	f        *os.File
+	r        *csv.Reader
+	headers  []string
+	warnings []string
+}
+
+// openCSV opens a delimited text file. A zero delimiter is sniffed from
+// the header line; a leading UTF-8 BOM is dropped so it doesn't end up in
+// the first column name. Text in a single-byte charset is transcoded to
+// UTF-8, detecting it first for CharsetAuto.
+func openCSV(filePath string, columns map[string]string, content []string, delimiter rune, charset, lang string) (*csvRows, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(f, charsetSample)
+	var warnings []string
+	if bom, _ := br.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		br.Discard(len(utf8BOM))
+		charset = CharsetUTF8
+	}
+	if charset == CharsetAuto {
+		sample, _ := br.Peek(charsetSample)
+		if charset = detectCharset(sample); charset != CharsetUTF8 {
+			warnings = append(warnings, messages.Text(lang, "charset_guessed", charset))
+		}
+	}
+	if cm := charmaps[charset]; cm != nil {
+		br = bufio.NewReader(transform.NewReader(br, cm.NewDecoder()))
+	}
+	if delimiter == 0 {
+		delimiter = sniffDelimiter(br)
+	}
+
+	r := csv.NewReader(br)
+	r.Comma = delimiter
+	headers, err := r.Read()
+	var headerWarnings []string
+	if err == nil {
+		headers, headerWarnings, err = headerKeys(headers, columns, content)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	warnings = append(warnings, headerWarnings...)
+	return &csvRows{f: f, r: r, headers: headers, warnings: warnings}, nil
+}
+
+func (c *csvRows) Next() (sourceRow, error) {
+	for {
+		record, err := c.r.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// malformed lines are skipped
+			continue
+		}
+		if err != nil {
+			return sourceRow{}, err
+		}
+		line, _ := c.r.FieldPos(0)
+		return sourceRow{Line: line, Values: rowValues(c.headers, record)}, nil
+	}
+}
+
+func (c *csvRows) Warnings() []string { return c.warnings }
+
+func (c *csvRows) Close() error {
+	return c.f.Close()
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// sniffDelimiter guesses the field separator from the first line, picking
+// whichever of ';', tab, '|' or ',' occurs most often outside quotes.
+// Comma wins ties and is the fallback.
+func sniffDelimiter(br *bufio.Reader) rune {
+	line, _ := br.Peek(br.Size())
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	counts := make(map[byte]int)
+	quoted := false
+	for _, b := range line {
+		switch b {
+		case '"':
+			quoted = !quoted
+		case ',', ';', '\t', '|':
+			if !quoted {
+				counts[b]++
+			}
+		}
+	}
+
+	best := byte(',')
+	for _, d := range []byte{';', '\t', '|'} {
+		if counts[d] > counts[best] {
+			best = d
+		}
+	}
+	return rune(best)
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readAll returns the values of every data row of filePath.
+func readAll(t *testing.T, filePath string, opts Options) []map[string]string {
+	t.Helper()
+	src, err := openRows(filePath, opts)
+	if err != nil {
+		t.Fatalf("openRows(%s): %v", filePath, err)
+	}
+	defer src.Close()
+
+	var rows []map[string]string
+	for {
+		row, err := src.Next()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatalf("reading %s: %v", filePath, err)
+		}
+		rows = append(rows, row.Values)
+	}
+}
+
+// numeric_nik.xlsx holds NIK and KK numbers typed as numbers, which
+// excelize shows in E notation ("3.20123456789E+15").
+func TestExcelNumericNIK(t *testing.T) {
+	rows := readAll(t, "testdata/numeric_nik.xlsx", Options{})
+	want := []struct{ nik, kk string }{
+		{"3201234567890001", "3201230101010002"},
+		{"3171011501900002", "3171010101010001"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i, w := range want {
+		if got := CleanNumber(rows[i]["NO IDENTITAS"]); got != w.nik {
+			t.Errorf("row %d: NIK %q, want %q", i+1, got, w.nik)
+		}
+		if got := CleanNumber(rows[i]["NOMOR KK"]); got != w.kk {
+			t.Errorf("row %d: KK %q, want %q", i+1, got, w.kk)
+		}
+	}
+}
+
+// writeTemp writes content to name in a fresh temporary directory.
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestDelimiters(t *testing.T) {
+	tests := []struct {
+		name, file string
+		sep        string
+		opts       Options
+	}{
+		{"comma", "in.csv", ",", Options{}},
+		{"semicolon", "in.csv", ";", Options{}},
+		{"tab", "in.tsv", "\t", Options{}},
+		{"pipe", "in.txt", "|", Options{}},
+		{"option", "in.csv", "\t", Options{Delimiter: "tab"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := strings.Join([]string{"NO IDENTITAS", "NOMOR KK", "NAMA LENGKAP", "KODE QR"}, tt.sep) + "\n" +
+				strings.Join([]string{"3201234567890001", "3201230101010002", "Aminah, Siti", "abc"}, tt.sep) + "\n"
+			if tt.sep == "," {
+				content = strings.Replace(content, "Aminah, Siti", `"Aminah, Siti"`, 1)
+			}
+			rows := readAll(t, writeTemp(t, tt.file, content), tt.opts)
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+			if got := rows[0]["NOMOR KK"]; got != "3201230101010002" {
+				t.Errorf("NOMOR KK = %q", got)
+			}
+			if got := rows[0]["NAMA LENGKAP"]; got != "Aminah, Siti" {
+				t.Errorf("NAMA LENGKAP = %q", got)
+			}
+		})
+	}
+}
+
+func TestCSVSemicolonAndBOM(t *testing.T) {
+	tests := []struct{ name, content string }{
+		{"semicolon", "NO IDENTITAS;NOMOR KK;NAMA LENGKAP;KODE QR\n3201234567890001;3201230101010002;Siti Aminah;abc\n"},
+		{"bom", "\ufeffNO IDENTITAS,NOMOR KK,NAMA LENGKAP,KODE QR\n3201234567890001,3201230101010002,Siti Aminah,abc\n"},
+		{"bom semicolon", "\ufeffNO IDENTITAS;NOMOR KK;NAMA LENGKAP;KODE QR\r\n3201234567890001;3201230101010002;Siti Aminah;abc\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := readAll(t, writeTemp(t, "in.csv", tt.content), Options{})
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+			// the BOM must not end up in the first column's key
+			if got := rows[0]["NO IDENTITAS"]; got != "3201234567890001" {
+				t.Errorf("NO IDENTITAS = %q, row %q", got, rows[0])
+			}
+			if got := rows[0]["KODE QR"]; got != "abc" {
+				t.Errorf("KODE QR = %q", got)
+			}
+		})
+	}
+}
+
+func TestNormalizeSpace(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Bandung", "Bandung"},
+		{"Bandung ", "Bandung"},
+		{"  Bandung\t", "Bandung"},
+		{"Bandung\u00a0", "Bandung"},
+		{"\u00a0Coblong\u00a0\u00a0Bandung", "Coblong Bandung"},
+		{"Siti   Aminah", "Siti Aminah"},
+		{"Siti \t\u00a0 Aminah", "Siti Aminah"},
+		{"line one  \r\n  line two", "line one\nline two"},
+		{" \u00a0\t ", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeSpace(tt.in); got != tt.want {
+			t.Errorf("normalizeSpace(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Cells as Excel exports them: trailing spaces, doubled spaces and
+// non-breaking spaces must not split one kecamatan into several folders.
+func TestMessyCells(t *testing.T) {
+	content := "NO IDENTITAS,NOMOR KK,NAMA LENGKAP,KODE QR,KECAMATAN\n" +
+		" 3201234567890001 ,3201230101010002,Siti  Aminah ,abc,Bandung \n" +
+		"3171011501900002,3171010101010001,\u00a0Budi\u00a0Santoso,def,\u00a0Bandung\u00a0\n" +
+		"3171011501900003,3171010101010001,Dewi\tLestari,ghi,\"  Bandung\"\n"
+	rows := readAll(t, writeTemp(t, "in.csv", content), Options{})
+	want := []struct{ nik, name string }{
+		{"3201234567890001", "Siti Aminah"},
+		{"3171011501900002", "Budi Santoso"},
+		{"3171011501900003", "Dewi Lestari"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i, w := range want {
+		if got := rows[i]["NO IDENTITAS"]; got != w.nik {
+			t.Errorf("row %d: NO IDENTITAS %q, want %q", i+1, got, w.nik)
+		}
+		if got := rows[i]["NAMA LENGKAP"]; got != w.name {
+			t.Errorf("row %d: NAMA LENGKAP %q, want %q", i+1, got, w.name)
+		}
+		if got := rows[i]["KECAMATAN"]; got != "Bandung" {
+			t.Errorf("row %d: KECAMATAN %q, want %q", i+1, got, "Bandung")
+		}
+	}
+}
+
+// windows1252.csv is a semicolon export from Excel on Windows, with
+// accented names and a curly apostrophe, which only Windows-1252 has.
+func TestWindows1252CSV(t *testing.T) {
+	want := []string{"José Ramírez", "Jürgen Müller", "Siobhán O’Brien"}
+	for _, charset := range []string{CharsetAuto, CharsetWindows1252, "cp1252"} {
+		rows := readAll(t, "testdata/windows1252.csv", Options{Charset: charset})
+		if len(rows) != len(want) {
+			t.Fatalf("charset %q: got %d rows, want %d", charset, len(rows), len(want))
+		}
+		for i, name := range want {
+			if got := rows[i]["NAMA LENGKAP"]; got != name {
+				t.Errorf("charset %q, row %d: NAMA LENGKAP %q, want %q", charset, i+1, got, name)
+			}
+		}
+	}
+
+	// accented letters are kept in file names, punctuation is not
+	rows := readAll(t, "testdata/windows1252.csv", Options{})
+	files := []string{
+		"3201234567890001-3201230101010002-José_Ramírez.png",
+		"3171011501900002-3171010101010001-Jürgen_Müller.png",
+		"3171011501900003-3171010101010001-Siobhán_O_Brien.png",
+	}
+	dir := t.TempDir()
+	for i, file := range files {
+		if status, msg := GenerateQR(rows[i], dir, Options{FolderLevels: []string{}}); status != "ok" {
+			t.Fatalf("row %d: GenerateQR = %q, %q", i+1, status, msg)
+		}
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("row %d: %v", i+1, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"image"
+	"image/color"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// RenderOptions are the resolved drawing settings for a single code,
+// independent of rows, files and output formats.
+type RenderOptions struct {
+	Level  qrcode.RecoveryLevel
+	Border int // quiet zone, in modules
+	Scale  int // pixels per module
+	Fg, Bg color.RGBA
+	// Padding is margin around the quiet zone, in pixels.
+	Padding int
+	// Logo, if set, is drawn over the center modules.
+	Logo image.Image
+	// Style is StyleSquare, StyleRounded or StyleDots.
+	Style string
+	// Supersample draws the image this many times larger and scales it
+	// down. Zero means DefaultSupersample.
+	Supersample int
+	// Gradient is a gradient direction, or empty for plain Fg modules.
+	Gradient    string
+	GradientEnd color.RGBA
+	// Eye and EyeFrame color the finder patterns and their outer frame;
+	// zero colors keep Fg.
+	Eye, EyeFrame color.RGBA
+	// MaxDimension rejects codes whose width would exceed it. Zero means
+	// DefaultMaxDimension.
+	MaxDimension int
+	// MaxImageMB rejects codes whose image would need more memory. Zero
+	// means DefaultMaxImageMB.
+	MaxImageMB int
+	// Lang is the language of the errors, see Options.Lang.
+	Lang string
+}
+
+// renderOptions resolves the drawing settings of opts.
+func (o Options) renderOptions() (RenderOptions, error) {
+	fg, bg, err := o.colors()
+	if err != nil {
+		return RenderOptions{}, err
+	}
+	level, err := o.recoveryLevel()
+	if err != nil {
+		return RenderOptions{}, err
+	}
+	logo, err := o.logoImage()
+	if err != nil {
+		return RenderOptions{}, err
+	}
+	gradient, gradientEnd, err := o.gradient()
+	if err != nil {
+		return RenderOptions{}, err
+	}
+	eye, eyeFrame, err := o.eyeColors()
+	if err != nil {
+		return RenderOptions{}, err
+	}
+	return RenderOptions{
+		Level:        level,
+		Border:       o.border(),
+		Padding:      o.Padding,
+		Scale:        o.scale(),
+		Fg:           fg,
+		Bg:           bg,
+		Logo:         logo,
+		Style:        o.moduleStyle(),
+		Supersample:  o.supersample(),
+		Gradient:     gradient,
+		GradientEnd:  gradientEnd,
+		Eye:          eye,
+		EyeFrame:     eyeFrame,
+		MaxDimension: o.maxDimension(),
+		MaxImageMB:   o.maxImageMB(),
+		Lang:         o.Lang,
+	}, nil
+}
+
+// newCanvas encodes content into a module matrix laid out per ro.
+func newCanvas(content string, ro RenderOptions) (canvas, error) {
+	qr, err := qrcode.New(content, ro.Level)
+	if err != nil {
+		return canvas{}, errors.New(messages.Text(ro.Lang, "qr_create_failed", err))
+	}
+	qr.DisableBorder = true // kita handle quiet zone secara manual
+
+	return canvas{
+		matrix:  qr.Bitmap(),
+		border:  ro.Border,
+		padding: ro.Padding,
+		scale:   ro.Scale,
+		fg:      ro.Fg,
+		bg:      ro.Bg,
+		logo:    ro.Logo,
+		style:   ro.Style,
+		samples: max(ro.Supersample, DefaultSupersample),
+
+		gradient:    ro.Gradient,
+		gradientEnd: ro.GradientEnd,
+		eye:         ro.Eye,
+		eyeFrame:    ro.EyeFrame,
+		lang:        ro.Lang,
+	}, nil
+}
+
+// qrVersion is the QR version (1 to 40) go-qrcode picks for content at
+// level; it has 17+4*version modules per side.
+func qrVersion(content string, level qrcode.RecoveryLevel, lang string) (int, error) {
+	qr, err := qrcode.New(content, level)
+	if err != nil {
+		return 0, errors.New(messages.Text(lang, "qr_create_failed", err))
+	}
+	return qr.VersionNumber, nil
+}
+
+// RenderQR draws content as a QR code image. It only turns the matrix
+// into pixels: no validation of rows, labels, files or encoding.
+func RenderQR(content string, ro RenderOptions) (image.Image, error) {
+	cv, err := newCanvas(content, ro)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := ro.MaxDimension
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDimension
+	}
+	if cv.size() > maxSize {
+		return nil, errors.New(messages.Text(ro.Lang, "image_too_large", cv.size(), maxSize))
+	}
+	if err := checkImageMemory(ro.Lang, cv.size(), cv.size(), ro.MaxImageMB); err != nil {
+		return nil, err
+	}
+	if err := cv.checkSupersampleMemory(ro.MaxImageMB); err != nil {
+		return nil, err
+	}
+	return renderImage(cv), nil
+}
+
+// checkImageMemory rejects a width x height RGBA image needing more than
+// maxMB megabytes, before anything is allocated. lang is the language of
+// the error.
+func checkImageMemory(lang string, width, height, maxMB int) error {
+	if maxMB <= 0 {
+		maxMB = DefaultMaxImageMB
+	}
+	need := int64(width) * int64(height) * 4
+	if need > int64(maxMB)<<20 {
+		return errors.New(messages.Text(lang, "image_memory", width, height, need>>20, maxMB))
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// goldenContent is a fixed payload shaped like the rows' content.
+const goldenContent = "3201234567890001|3201230101010002|SITI AMINAH"
+
+// TestRenderGolden renders fixed content and compares the pixels with the
+// PNGs in testdata/golden. After an intended change to the output, run
+// "go test ./service -run RenderGolden -update" and review the new images.
+func TestRenderGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"default", Options{}},
+		{"color", Options{FgColor: "#1A4D8F", BgColor: "#FFF8E1"}},
+		{"scale4", Options{Scale: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ro, err := tt.opts.renderOptions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := RenderQR(goldenContent, ro)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "golden", tt.name+".png")
+			if *update {
+				var buf bytes.Buffer
+				enc := png.Encoder{CompressionLevel: png.BestCompression}
+				if err := enc.Encode(&buf, img); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			f, err := os.Open(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			defer f.Close()
+			want, err := png.Decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if x, y, ok := samePixels(toRGBA(img), toRGBA(want)); !ok {
+				t.Errorf("render differs from %s at (%d,%d); got %v, want %v",
+					golden, x, y, img.Bounds(), want.Bounds())
+			}
+		})
+	}
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// samePixels reports whether a and b are identical, and otherwise the
+// first pixel where they differ.
+func samePixels(a, b *image.RGBA) (x, y int, ok bool) {
+	if a.Rect != b.Rect {
+		return 0, 0, false
+	}
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		row := a.PixOffset(0, y)
+		if !bytes.Equal(a.Pix[row:row+4*a.Rect.Dx()], b.Pix[row:row+4*b.Rect.Dx()]) {
+			for x := 0; x < a.Rect.Dx(); x++ {
+				if a.RGBAAt(x, y) != b.RGBAAt(x, y) {
+					return x, y, false
+				}
+			}
+		}
+	}
+	return 0, 0, true
+}
+
+// BenchmarkPNGCompression encodes a code at the default scale with each
+// PNGCompression level, reporting the file size next to the time.
+func BenchmarkPNGCompression(b *testing.B) {
+	ro, err := Options{}.renderOptions()
+	if err != nil {
+		b.Fatal(err)
+	}
+	img, err := RenderQR(goldenContent, ro)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, level := range []string{PNGBest, PNGDefault, PNGSpeed, PNGNone} {
+		b.Run(level, func(b *testing.B) {
+			enc := png.Encoder{CompressionLevel: Options{PNGCompression: level}.pngLevel()}
+			var buf bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := enc.Encode(&buf, img); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(buf.Len()), "bytes")
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrorReportFilename is the plain-text list of failed rows written into
+// the output when any row is invalid or fails.
+const ErrorReportFilename = "errors.txt"
+
+// failedRows returns the rows that produced no image because they were
+// invalid or failed, in file order.
+func failedRows(result *Result) []RowResult {
+	var failed []RowResult
+	for _, rr := range result.Rows {
+		if rr.Status == "invalid" || rr.Status == "error" {
+			failed = append(failed, rr)
+		}
+	}
+	return failed
+}
+
+// writeErrorReport lists every failed row with its message, which already
+// names the row, so the source data can be fixed without the manifest.
+func writeErrorReport(w io.Writer, failed []RowResult) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d row(s) produced no QR code:\r\n\r\n", len(failed))
+	for _, rr := range failed {
+		fmt.Fprintf(bw, "%s\r\n", rr.Message)
+	}
+	return bw.Flush()
+}
+
+// saveErrorReport writes ErrorReportFilename into outputFolder when any
+// row failed.
+func saveErrorReport(outputFolder string, result *Result, opts Options) error {
+	failed := failedRows(result)
+	if len(failed) == 0 {
+		// a folder reused across runs must not keep an earlier report
+		if err := os.Remove(filepath.Join(outputFolder, ErrorReportFilename)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	f, err := opts.createFile(filepath.Join(outputFolder, ErrorReportFilename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeErrorReport(f, failed); err != nil {
+		return err
+	}
+	return f.Close()
+}
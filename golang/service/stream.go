@@ -0,0 +1,129 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ZipStream generates QR codes in memory and writes them straight into a
+// zip archive, so nothing is left on disk. The spreadsheet header is read
+// and validated by OpenZipStream, letting callers report input errors
+// before any output has been written; rows are then read as they are
+// rendered. The file must stay in place until Close.
+type ZipStream struct {
+	path  string
+	src   rowReader
+	total int
+	opts  Options
+}
+
+// OpenZipStream opens filePath and prepares it for streaming.
+func OpenZipStream(filePath string, opts Options) (*ZipStream, error) {
+	src, total, err := openSource(filePath, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ZipStream{path: filePath, src: src, total: total, opts: opts}, nil
+}
+
+// Filename is the name to offer the archive under when its entries live
+// under root: Options.ZipName if set, else "<root>.zip".
+func (s *ZipStream) Filename(root string) string {
+	return s.opts.zipBase(root) + ".zip"
+}
+
+// Close releases the spreadsheet.
+func (s *ZipStream) Close() error {
+	return s.src.Close()
+}
+
+// WriteZip renders every row and writes the images to w as a zip archive
+// whose entries live under root, mirroring the on-disk folder layout.
+// Rows that map to a file already written in this archive are skipped.
+// Like Generate it stops early once ctx is done.
+func (s *ZipStream) WriteZip(ctx context.Context, w io.Writer, root string) (*Result, error) {
+	archive := zip.NewWriter(w)
+	var mu sync.Mutex
+	written := make(map[string]bool)
+
+	result, err := processRows(ctx, s.src, s.total, s.opts, func(row sourceRow) (string, string) {
+		plan, status, msg := planRow(row, s.opts)
+		if plan == nil {
+			return status, msg
+		}
+
+		name := path.Join(root, filepath.ToSlash(plan.Dir), plan.Filename)
+		mu.Lock()
+		seen := written[name]
+		written[name] = true
+		mu.Unlock()
+		if seen {
+			return "skip", plan.Filename
+		}
+
+		var buf bytes.Buffer
+		if err := writeQR(&buf, plan, s.opts); err != nil {
+			return "error", err.Error()
+		}
+
+		// zip.Writer is not safe for concurrent use, so entries are
+		// appended one at a time while rendering stays parallel.
+		mu.Lock()
+		defer mu.Unlock()
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   s.opts.zipMethod(),
+			Modified: time.Now(),
+		})
+		if err == nil {
+			_, err = entry.Write(buf.Bytes())
+		}
+		if err != nil {
+			return "error", fmt.Sprintf("Failed to write zip entry: %v", err)
+		}
+		return "ok", plan.Filename
+	})
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	if s.opts.Manifest {
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     path.Join(root, ManifestFilename),
+			Method:   s.opts.zipMethod(),
+			Modified: time.Now(),
+		})
+		if err == nil {
+			err = writeManifest(entry, s.path, result, s.opts)
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to write manifest: %v", err)
+		}
+	}
+	if failed := failedRows(result); len(failed) > 0 {
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     path.Join(root, ErrorReportFilename),
+			Method:   s.opts.zipMethod(),
+			Modified: time.Now(),
+		})
+		if err == nil {
+			err = writeErrorReport(entry, failed)
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to write error report: %v", err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return result, fmt.Errorf("failed to zip: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Module styles: plain squares, squares with rounded corners, or dots.
+// Finder patterns are always drawn as squares so codes stay scannable.
+const (
+	StyleSquare  = "square"
+	StyleRounded = "rounded"
+	StyleDots    = "dots"
+)
+
+// Supersampling factors: the image is drawn n times larger, then scaled
+// down. 1 draws at the final size.
+const (
+	DefaultSupersample = 1
+	MaxSupersample     = 4
+)
+
+// Gradient directions for Options.GradientDirection.
+const (
+	GradientVertical   = "vertical"
+	GradientHorizontal = "horizontal"
+	GradientDiagonal   = "diagonal"
+)
+
+// gradientPosition is how far module (x, y) lies along the gradient, from
+// 0 at the start color to 1 at the end color.
+func gradientPosition(direction string, x, y, modules int) float64 {
+	last := float64(max(modules-1, 1))
+	switch direction {
+	case GradientHorizontal:
+		return float64(x) / last
+	case GradientDiagonal:
+		return float64(x+y) / (2 * last)
+	}
+	return float64(y) / last
+}
+
+// roundedRadius is the corner radius of a rounded module, as a share of
+// the module size.
+const roundedRadius = 0.3
+
+// inFinder reports whether module (x, y) belongs to one of the three 7x7
+// finder patterns of a code that is modules wide.
+func inFinder(x, y, modules int) bool {
+	return (x < 7 && y < 7) || (x >= modules-7 && y < 7) || (x < 7 && y >= modules-7)
+}
+
+// inFinderFrame reports whether module (x, y) lies on the outer ring of a
+// finder pattern, as opposed to its 3x3 center. Callers check inFinder
+// first.
+func inFinderFrame(x, y, modules int) bool {
+	lx, ly := x, y
+	if x >= modules-7 {
+		lx = x - (modules - 7)
+	}
+	if y >= modules-7 {
+		ly = y - (modules - 7)
+	}
+	return lx == 0 || lx == 6 || ly == 0 || ly == 6
+}
+
+// moduleMask returns the coverage of one styled module of scale pixels,
+// sampled 4x4 per pixel so edges are anti-aliased. Square modules have no
+// mask.
+func moduleMask(style string, scale int) *image.Alpha {
+	if style == StyleSquare {
+		return nil
+	}
+
+	const samples = 4
+	mask := image.NewAlpha(image.Rect(0, 0, scale, scale))
+	size := float64(scale)
+	for py := 0; py < scale; py++ {
+		for px := 0; px < scale; px++ {
+			hits := 0
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					x := float64(px) + (float64(sx)+0.5)/samples
+					y := float64(py) + (float64(sy)+0.5)/samples
+					if insideModule(style, x, y, size) {
+						hits++
+					}
+				}
+			}
+			mask.SetAlpha(px, py, color.Alpha{uint8(hits * 255 / (samples * samples))})
+		}
+	}
+	return mask
+}
+
+// insideModule reports whether point (x, y) of a size-wide cell is covered.
+func insideModule(style string, x, y, size float64) bool {
+	switch style {
+	case StyleDots:
+		r := size / 2
+		return math.Hypot(x-r, y-r) <= r
+	case StyleRounded:
+		r := size * roundedRadius
+		// distance from the inner rectangle the corners are rounded around
+		dx := math.Max(math.Max(r-x, x-(size-r)), 0)
+		dy := math.Max(math.Max(r-y, y-(size-r)), 0)
+		return math.Hypot(dx, dy) <= r
+	}
+	return true
+}
+
+// writeSVGModule writes one module at (px, py) in the given style.
+func writeSVGModule(w io.Writer, style string, px, py, scale int, fill string) {
+	switch style {
+	case StyleDots:
+		r := float64(scale) / 2
+		fmt.Fprintf(w, `<circle cx="%g" cy="%g" r="%g" fill="%s"/>`+"\n", float64(px)+r, float64(py)+r, r, fill)
+	case StyleRounded:
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" rx="%g" fill="%s"/>`+"\n", px, py, scale, scale, float64(scale)*roundedRadius, fill)
+	default:
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", px, py, scale, scale, fill)
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SweepStaleZips deletes zip files directly inside dir that were last
+// modified more than ttl ago and returns the paths it removed.
+func SweepStaleZips(dir string, ttl time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	cutoff := time.Now().Add(-ttl)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".zip") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
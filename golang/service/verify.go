@@ -0,0 +1,38 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// verifyImage decodes img and checks that it reads back as content.
+// Transparent pixels would read as black, so img is scanned as if printed
+// on white.
+func verifyImage(img image.Image, content string) error {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	bmp, err := gozxing.NewBinaryBitmapFromImage(flat)
+	if err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+
+	// the encoder writes content as UTF-8 bytes; pure barcode mode reads
+	// codes rendered without a quiet zone too
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_CHARACTER_SET: "UTF-8",
+		gozxing.DecodeHintType_PURE_BARCODE:  true,
+	}
+	decoded, err := qrcode.NewQRCodeReader().Decode(bmp, hints)
+	if err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	if decoded.GetText() != content {
+		return fmt.Errorf("verification failed: decoded %q", decoded.GetText())
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import "strings"
+
+// Columns read in WiFi mode. Only the SSID column is required; without
+// AUTH the type follows from whether there is a password.
+const (
+	WiFiSSIDColumn     = "SSID"
+	WiFiPasswordColumn = "PASSWORD"
+	WiFiAuthColumn     = "AUTH"
+)
+
+// WiFi authentication types, as written in the payload's T field.
+const (
+	WiFiWPA    = "WPA"
+	WiFiWEP    = "WEP"
+	WiFiNoPass = "nopass"
+)
+
+// wifiAuth maps an AUTH cell to the payload's type. WPA2 and WPA3 are
+// written as WPA, which scanners use for every WPA generation.
+func wifiAuth(cell, password string) (string, bool) {
+	switch strings.ToUpper(strings.TrimSpace(cell)) {
+	case "":
+		if password == "" {
+			return WiFiNoPass, true
+		}
+		return WiFiWPA, true
+	case "WPA", "WPA2", "WPA3", "WPA/WPA2":
+		return WiFiWPA, true
+	case "WEP":
+		return WiFiWEP, true
+	case "NOPASS", "NONE", "OPEN":
+		return WiFiNoPass, true
+	}
+	return "", false
+}
+
+// wifiEscape escapes a value per the WIFI: payload format: backslash
+// before \ ; , " and :, and double quotes around values that would read
+// as hex.
+func wifiEscape(value string) string {
+	if isHex(value) {
+		return `"` + value + `"`
+	}
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `"`, `\"`, `:`, `\:`).Replace(value)
+}
+
+func isHex(s string) bool {
+	if s == "" || len(s)%2 != 0 {
+		return false
+	}
+	return strings.Trim(s, "0123456789abcdefABCDEF") == ""
+}
+
+// wifiPayload builds the network join payload for a row, e.g.
+// "WIFI:T:WPA;S:Kiosk;P:secret;;", or returns why it can't.
+func wifiPayload(row map[string]string, opts Options) (string, string) {
+	ssid := strings.TrimSpace(row[WiFiSSIDColumn])
+	if ssid == "" {
+		return "", opts.text("wifi_no_ssid")
+	}
+	password := row[WiFiPasswordColumn]
+	auth, ok := wifiAuth(row[WiFiAuthColumn], password)
+	if !ok {
+		return "", opts.text("wifi_auth", strings.TrimSpace(row[WiFiAuthColumn]))
+	}
+	if auth != WiFiNoPass && password == "" {
+		return "", opts.text("wifi_no_password", auth)
+	}
+
+	payload := "WIFI:T:" + auth + ";S:" + wifiEscape(ssid) + ";"
+	if auth != WiFiNoPass {
+		payload += "P:" + wifiEscape(password) + ";"
+	}
+	return payload + ";", ""
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// zipEntryOverhead bounds the header bytes an entry adds besides its
+// name, counted twice: local header (30) and central directory record
+// (46), each with room for the extended timestamp and zip64 fields.
+const zipEntryOverhead = 30 + 46 + 2*(9+28)
+
+// zipEndOverhead bounds the end of central directory records, zip64
+// ones included.
+const zipEndOverhead = 22 + 56 + 20
+
+// zipParts writes a folder's entries into target, rolling over to
+// "<name>.part2.zip" and so on when Options.MaxZipBytes would be
+// exceeded. Once a second part is needed the first is renamed to
+// "<name>.part1.zip". Each file goes whole into one part; a file larger
+// than the limit gets a part of its own.
+type zipParts struct {
+	target  string
+	limit   int64
+	opts    Options
+	file    *os.File
+	archive *zip.Writer
+	written *countingWriter
+	central int64 // central directory bytes the open part will need
+	entries int
+	names   []string
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newZipParts(target string, opts Options) *zipParts {
+	return &zipParts{target: target, limit: opts.MaxZipBytes, opts: opts}
+}
+
+// partPath is the path of the given 1-based part.
+func (z *zipParts) partPath(part int) string {
+	return strings.TrimSuffix(z.target, ".zip") + ".part" + strconv.Itoa(part) + ".zip"
+}
+
+// removeStale deletes the parts of an earlier run, which a new set with
+// fewer parts would otherwise leave behind.
+func (z *zipParts) removeStale() error {
+	prefix := strings.TrimSuffix(filepath.Base(z.target), ".zip") + ".part"
+	entries, err := os.ReadDir(filepath.Dir(z.target))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		part, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if part, ok = strings.CutSuffix(part, ".zip"); !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(part); err != nil || n < 1 {
+			continue
+		}
+		if err := os.Remove(filepath.Join(filepath.Dir(z.target), entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open starts the next part.
+func (z *zipParts) open() error {
+	path := z.target
+	if len(z.names) > 0 {
+		path = z.partPath(len(z.names) + 1)
+	}
+	file, err := z.opts.createFile(path)
+	if err != nil {
+		return err
+	}
+	z.file = file
+	z.written = &countingWriter{w: file}
+	z.archive = zip.NewWriter(z.written)
+	z.central, z.entries = 0, 0
+	z.names = append(z.names, filepath.Base(path))
+	return nil
+}
+
+// finish closes the open part.
+func (z *zipParts) finish() error {
+	if z.file == nil {
+		return nil
+	}
+	err := z.archive.Close()
+	if cerr := z.file.Close(); err == nil {
+		err = cerr
+	}
+	z.file = nil
+	return err
+}
+
+// next closes the open part and starts another, renaming the first part
+// once it turns out not to be the only one.
+func (z *zipParts) next() error {
+	if err := z.finish(); err != nil {
+		return err
+	}
+	if len(z.names) == 1 {
+		if err := os.Rename(z.target, z.partPath(1)); err != nil {
+			return err
+		}
+		z.names[0] = filepath.Base(z.partPath(1))
+	}
+	return z.open()
+}
+
+// add writes the entry for header, reading the file's content from path
+// unless header is a folder.
+func (z *zipParts) add(header *zip.FileHeader, path string) error {
+	if z.file == nil {
+		if err := z.open(); err != nil {
+			return err
+		}
+	}
+	isDir := strings.HasSuffix(header.Name, "/")
+	if z.limit <= 0 {
+		writer, err := z.archive.CreateHeader(header)
+		if err != nil || isDir {
+			return err
+		}
+		return copyFile(writer, path)
+	}
+
+	// Compress up front so the entry's exact size is known before
+	// choosing its part.
+	var data []byte
+	if isDir {
+		// what CreateHeader does for folders: no content, no sizes
+		header.Method = zip.Store
+		header.CompressedSize64, header.UncompressedSize64 = 0, 0
+	} else {
+		var err error
+		if data, err = compressFile(path, header); err != nil {
+			return err
+		}
+	}
+	if err := z.archive.Flush(); err != nil {
+		return err
+	}
+	size := zipEntryOverhead + 2*int64(len(header.Name)) + int64(len(data))
+	if z.entries > 0 && z.written.n+z.central+size+zipEndOverhead > z.limit {
+		if err := z.next(); err != nil {
+			return err
+		}
+	}
+	writer, err := z.archive.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	z.central += 46 + 9 + 28 + int64(len(header.Name)) // as in zipEntryOverhead
+	z.entries++
+	return nil
+}
+
+// compressFile reads path into a buffer compressed with header.Method,
+// filling in the sizes and checksum CreateRaw needs.
+func compressFile(path string, header *zip.FileHeader) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data := content
+	if header.Method == zip.Deflate {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(content); err != nil {
+			return nil, err
+		}
+		if err := fw.Close(); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	header.CRC32 = crc32.ChecksumIEEE(content)
+	header.UncompressedSize64 = uint64(len(content))
+	header.CompressedSize64 = uint64(len(data))
+	return data, nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
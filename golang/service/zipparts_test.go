@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// pngFolder fills a new folder "batch" with n files of random, and so
+// incompressible, bytes like PNGs.
+func pngFolder(t testing.TB, n, size int) string {
+	t.Helper()
+	source := filepath.Join(t.TempDir(), "batch")
+	if err := os.Mkdir(source, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		data := make([]byte, size)
+		rand.Read(data)
+		if err := os.WriteFile(filepath.Join(source, "qr"+string(rune('a'+i))+".png"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+// Re-zipping into the same folder must not leave parts of the earlier,
+// longer set behind.
+func TestZipFolderRemovesStaleParts(t *testing.T) {
+	source := pngFolder(t, 3, 2000)
+	out := t.TempDir()
+	target := filepath.Join(out, "batch.zip")
+	opts := Options{MaxZipBytes: 3000, ZipCompression: ZipStore}
+
+	zipped := func() []string {
+		t.Helper()
+		names, err := zipFolder(context.Background(), source, target, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, entry := range entries {
+			files = append(files, entry.Name())
+		}
+		if !slices.Equal(files, names) {
+			t.Errorf("folder holds %v, want only %v", files, names)
+		}
+		return names
+	}
+
+	if names := zipped(); len(names) != 3 {
+		t.Fatalf("first run wrote %v, want 3 parts", names)
+	}
+	os.Remove(filepath.Join(source, "qrc.png"))
+	if names := zipped(); len(names) != 2 {
+		t.Errorf("second run wrote %v, want 2 parts", names)
+	}
+	opts.MaxZipBytes = 0
+	if names := zipped(); !slices.Equal(names, []string{"batch.zip"}) {
+		t.Errorf("unsplit run wrote %v, want batch.zip", names)
+	}
+}